@@ -1,412 +1,1046 @@
-package main
+package golftalk
 
 import (
 	"bufio"
-	"fmt"
 	"math"
+	"math/big"
 	"os"
+	"reflect"
+	"strings"
+	"unicode/utf8"
 )
 
-func add(args ...Expression) (Expression, string) {
-	useFloat := false
-	accumulator := PTInt(0)
-	fAccumulator := PTFloat(0.0)
+func add(args ...Expression) (Expression, error) {
+	var accumulator Expression = PTInt(0)
 	for _, val := range args {
-		i, wasInt := val.(PTInt)
-		f, wasFloat := val.(PTFloat)
-
-		if wasFloat {
-			// Floats can only be added to the float accumulator
-			fAccumulator += f
-			useFloat = true
-		} else if wasInt {
-			// Ints can be added to floats and ints
-			accumulator += i
-			fAccumulator += PTFloat(i)
-		} else {
-			return nil, "Invalid types to add. Must all be int or float."
+		var ok bool
+		accumulator, ok = addOp.apply(accumulator, val)
+		if !ok {
+			return nil, errorf(TypeError, "Invalid types to add. Must all be int or float.")
 		}
 	}
 
-	if useFloat {
-		return fAccumulator, ""
-	}
-
-	return accumulator, ""
+	return accumulator, nil
 }
 
-func subtract(args ...Expression) (Expression, string) {
+func subtract(args ...Expression) (Expression, error) {
 	switch len(args) {
 	case 0:
-		return nil, "Need at least 1 value to subtract."
+		return nil, errorf(ArityError, "Need at least 1 value to subtract.")
 	case 1:
-		i, wasInt := args[0].(PTInt)
-		f, wasFloat := args[0].(PTFloat)
-
-		if wasInt {
-			return 0 - i, ""
-		} else if wasFloat {
-			return 0 - f, ""
-		} else if !wasInt && !wasFloat {
-			return nil, "Invalid types to subtract. Must all be int or float."
+		// Negate
+		args = []Expression{PTInt(0), args[0]}
+	}
+
+	if !isNumber(args[0]) {
+		return nil, errorf(TypeError, "Invalid types to subtract. Must all be int or float.")
+	}
+
+	accumulator := args[0]
+	for _, val := range args[1:] {
+		var ok bool
+		accumulator, ok = subtractOp.apply(accumulator, val)
+		if !ok {
+			return nil, errorf(TypeError, "Invalid types to subtract. Must all be int or float.")
 		}
+	}
+
+	return accumulator, nil
+}
 
-		return nil, "Something went very wrong in the subtract function."
+func multiply(args ...Expression) (Expression, error) {
+	var accumulator Expression = PTInt(1)
+	for _, val := range args {
+		var ok bool
+		accumulator, ok = multiplyOp.apply(accumulator, val)
+		if !ok {
+			return nil, errorf(TypeError, "Invalid types to multiply. Must all be int or float.")
+		}
 	}
 
-	useFloat := false
-	accumulator := PTInt(0)
-	fAccumulator := PTFloat(0.0)
-	for idx, val := range args {
-		i, wasInt := val.(PTInt)
-		f, wasFloat := val.(PTFloat)
+	return accumulator, nil
+}
+
+func divide(args ...Expression) (Expression, error) {
+	if len(args) == 0 || !isNumber(args[0]) {
+		return nil, errorf(TypeError, "Invalid types to divide. Must all be int or float.")
+	}
 
-		if !wasInt && !wasFloat {
-			return nil, "Invalid types to subtract. Must all be int or float."
+	accumulator := args[0]
+	for _, val := range args[1:] {
+		if isZero(val) {
+			return nil, errorf(ValueError, "Division by zero is currently unsupported.")
 		}
 
-		if idx == 0 {
-			if wasInt {
-				accumulator += i
-				fAccumulator += PTFloat(i)
-			} else if wasFloat {
-				fAccumulator += f
-				useFloat = true
-			}
-		} else {
-			if wasInt {
-				accumulator -= i
-				fAccumulator -= PTFloat(i)
-			} else if wasFloat {
-				fAccumulator -= f
-				useFloat = true
-			}
+		var ok bool
+		accumulator, ok = divideOp.apply(accumulator, val)
+		if !ok {
+			return nil, errorf(TypeError, "Invalid types to divide. Must all be int or float.")
 		}
 	}
 
-	if useFloat {
-		return fAccumulator, ""
+	return accumulator, nil
+}
+
+func mod(args ...Expression) (Expression, error) {
+	a, aok := toBig(args[0])
+	b, bok := toBig(args[1])
+
+	if !aok || !bok {
+		return nil, errorf(TypeError, "Invalid types to divide. Must be int and int.")
 	}
 
-	return accumulator, ""
-}
+	if b.Sign() == 0 {
+		return nil, errorf(ValueError, "Division by zero is currently unsupported.")
+	}
 
-func multiply(args ...Expression) (Expression, string) {
-	useFloat := false
-	accumulator := PTInt(1)
-	fAccumulator := PTFloat(1.0)
-	for _, val := range args {
-		i, wasInt := val.(PTInt)
-		f, wasFloat := val.(PTFloat)
-
-		if wasFloat {
-			fAccumulator *= f
-			useFloat = true
-		} else if wasInt {
-			accumulator *= i
-			fAccumulator *= PTFloat(i)
-		} else {
-			return nil, "Invalid types to multiply. Must all be int or float."
+	// Stay out of math/big for the common case
+	if i, wasInt := args[0].(PTInt); wasInt {
+		if j, wasInt := args[1].(PTInt); wasInt {
+			return i % j, nil
 		}
 	}
 
-	if useFloat {
-		return fAccumulator, ""
+	return normalizeBig(new(big.Int).Rem(a, b)), nil
+}
+
+func quotient(args ...Expression) (Expression, error) {
+	if len(args) != 2 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 2 arguments.")
+	}
+
+	a, aok := toBig(args[0])
+	b, bok := toBig(args[1])
+
+	if !aok || !bok {
+		return nil, errorf(TypeError, "Invalid types to divide. Must be int and int.")
+	}
+
+	if b.Sign() == 0 {
+		return nil, errorf(ValueError, "Division by zero is currently unsupported.")
 	}
 
-	return accumulator, ""
+	return normalizeBig(new(big.Int).Quo(a, b)), nil
 }
 
-func divide(args ...Expression) (Expression, string) {
-	useFloat := false
-	accumulator := PTInt(0)
-	fAccumulator := PTFloat(0.0)
-	for idx, val := range args {
-		i, wasInt := val.(PTInt)
-		f, wasFloat := val.(PTFloat)
+func numerator(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
 
-		// Initialize accumulators with the first value
-		if idx == 0 {
-			if wasInt {
-				accumulator = i
-				fAccumulator = PTFloat(i)
-			} else {
-				fAccumulator = f
-				useFloat = true
-			}
+	r, ok := toRat(args[0])
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only take the numerator of an exact number.")
+	}
 
-			continue
-		}
+	return normalizeBig(new(big.Int).Set(r.Num())), nil
+}
 
-		if (wasInt && i == 0) || (wasFloat && f == 0.0) {
-			return nil, "Division by zero is currently unsupported."
-		}
+func denominator(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
 
-		if wasFloat {
-			fAccumulator /= f
-			useFloat = true
-		} else if wasInt {
-			accumulator /= i
-			fAccumulator /= PTFloat(i)
-		} else {
-			return nil, "Invalid types to divide. Must all be int or float."
-		}
+	r, ok := toRat(args[0])
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only take the denominator of an exact number.")
 	}
 
-	// If we used floats but it was equivalent to an integer, return an integer
-	if PTFloat(accumulator) == fAccumulator {
-		return accumulator, ""
+	return normalizeBig(new(big.Int).Set(r.Denom())), nil
+}
+
+func exactToInexact(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
 	}
 
-	if useFloat {
-		return fAccumulator, ""
+	f, ok := toFloat(args[0])
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only convert a number.")
 	}
 
-	return accumulator, ""
+	return f, nil
 }
 
-func mod(args ...Expression) (Expression, string) {
-	a, aok := args[0].(PTInt)
-	b, bok := args[1].(PTInt)
+func inexactToExact(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
 
-	if !aok || !bok {
-		return nil, "Invalid types to divide. Must be int and int."
+	if isExact(args[0]) {
+		return args[0], nil
 	}
 
-	if b == 0 {
-		return nil, "Division by zero is currently unsupported."
+	f, ok := args[0].(PTFloat)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only convert a number.")
 	}
 
-	return a % b, ""
-}
+	r := new(big.Rat)
+	if r.SetFloat64(float64(f)) == nil {
+		return nil, errorf(ValueError, "%s has no exact representation.", f)
+	}
 
-func sqrt(args ...Expression) (Expression, string) {
-	i, wasInt := args[0].(PTInt)
-	f, wasFloat := args[0].(PTFloat)
+	return normalizeRat(r), nil
+}
 
-	result := PTFloat(0.0)
+func sqrt(args ...Expression) (Expression, error) {
+	f, wasNum := toFloat(args[0])
+	if !wasNum {
+		return nil, errorf(TypeError, "Invalid type for square root. Must be int or float.")
+	}
 
-	if wasInt {
-		result = PTFloat(math.Sqrt(float64(i)))
-	} else if wasFloat {
-		result = PTFloat(math.Sqrt(float64(f)))
-	} else {
-		return nil, "Invalid type for square root. Must be int or float."
+	// Return an exact integer iff we got a perfect square
+	if i, wasInt := toBig(args[0]); wasInt && i.Sign() >= 0 {
+		root := new(big.Int).Sqrt(i)
+		if new(big.Int).Mul(root, root).Cmp(i) == 0 {
+			return normalizeBig(root), nil
+		}
 	}
 
-	// Return an int iff we got a perfect square
-	if math.Floor(float64(result)) == float64(result) {
-		return PTInt(result), ""
+	result := PTFloat(math.Sqrt(float64(f)))
+	if _, wasFloat := args[0].(PTFloat); wasFloat && math.Floor(float64(result)) == float64(result) {
+		return PTInt(result), nil
 	}
 
-	return result, ""
+	return result, nil
 }
 
-func or(args ...Expression) (Expression, string) {
+func or(args ...Expression) (Expression, error) {
 	a, aok := args[0].(PTBool)
 	b, bok := args[1].(PTBool)
 
 	if !aok || !bok {
-		return nil, fmt.Sprintf("Invalid types to compare. Must be bool and bool.")
+		return nil, errorf(TypeError, "Invalid types to compare. Must be bool and bool.")
 	}
 
-	return a || b, ""
+	return a || b, nil
 }
 
-func and(args ...Expression) (Expression, string) {
+func and(args ...Expression) (Expression, error) {
 	a, aok := args[0].(PTBool)
 	b, bok := args[1].(PTBool)
 
 	if !aok || !bok {
-		return nil, "Invalid types to compare. Must be bool and bool."
+		return nil, errorf(TypeError, "Invalid types to compare. Must be bool and bool.")
 	}
 
-	return a && b, ""
+	return a && b, nil
 }
 
-func not(args ...Expression) (Expression, string) {
+func not(args ...Expression) (Expression, error) {
 	a, aok := args[0].(PTBool)
 
 	if !aok {
-		return nil, "Invalid type to invert. Must be bool."
+		return nil, errorf(TypeError, "Invalid type to invert. Must be bool.")
+	}
+
+	return !a, nil
+}
+
+func mostProbably(args ...Expression) (Expression, error) {
+	f1, wasNum1 := toFloat(args[0])
+	f2, wasNum2 := toFloat(args[1])
+
+	if !wasNum1 || !wasNum2 {
+		return nil, errorf(TypeError, "Invalid types to compare. Each must be int or float.")
 	}
 
-	return !a, ""
+	if isExact(args[0]) && isExact(args[1]) {
+		cmp, _ := compareNums(args[0], args[1])
+		return PTBool(cmp == 0), nil
+	}
+
+	return PTBool(math.Abs(float64(f1)-float64(f2)) < 0.5), nil
 }
 
-func mostProbably(args ...Expression) (Expression, string) {
-	i1, wasInt1 := args[0].(PTInt)
-	f1, wasFloat1 := args[0].(PTFloat)
+func readLine(args ...Expression) (Expression, error) {
+	in := bufio.NewReader(os.Stdin)
+	line, err := in.ReadString('\n')
+
+	if err != nil {
+		return nil, errorf(ValueError, "%s", err)
+	}
 
-	i2, wasInt2 := args[1].(PTInt)
-	f2, wasFloat2 := args[1].(PTFloat)
+	return PTString(strings.TrimSuffix(line, "\n")), nil
+}
 
-	if (!wasInt1 && !wasFloat1) || (!wasInt2 && !wasFloat2) {
-		return nil, "Invalid types to compare. Each must be int or float."
+func equals(args ...Expression) (Expression, error) {
+	// Exact numbers are compared by value, since arithmetic may build the same big one twice
+	if isExact(args[0]) && isExact(args[1]) {
+		cmp, _ := compareNums(args[0], args[1])
+		return PTBool(cmp == 0), nil
+	}
+	// Go can't compare functions with ==, so core forms are compared by the function they run
+	if f1, ok := args[0].(CoreFunc); ok {
+		f2, ok := args[1].(CoreFunc)
+		return PTBool(ok && reflect.ValueOf(f1).Pointer() == reflect.ValueOf(f2).Pointer()), nil
 	}
+	if _, ok := args[1].(CoreFunc); ok {
+		return PTBool(false), nil
+	}
+
+	return PTBool(args[0] == args[1]), nil
+}
 
-	if wasInt1 && wasInt2 {
-		if i1 == i2 {
-			return PTBool(true), ""
+// isEqual compares values by structure: lists, vectors and records are equal if their elements are, and anything else is compared like eq?.
+func isEqual(a, b Expression) bool {
+	switch x := a.(type) {
+	case *SexpPair:
+		y, ok := b.(*SexpPair)
+		if !ok || x == EmptyList || y == EmptyList {
+			return ok && x == y
 		}
-	} else if wasInt1 && wasFloat2 {
-		if math.Abs(float64(i1)-float64(f2)) < 0.5 {
-			return PTBool(true), ""
+		return isEqual(x.val, y.val) && isEqual(x.next, y.next)
+	case *PTVector:
+		y, ok := b.(*PTVector)
+		return ok && allEqual(x.Items, y.Items)
+	case *PTRecord:
+		y, ok := b.(*PTRecord)
+		return ok && x.Type == y.Type && allEqual(x.Values, y.Values)
+	case *PTValues:
+		y, ok := b.(*PTValues)
+		return ok && allEqual(x.Items, y.Items)
+	}
+	same, _ := equals(a, b)
+	return bool(same.(PTBool))
+}
+
+func allEqual(a, b []Expression) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !isEqual(a[i], b[i]) {
+			return false
 		}
-	} else if wasFloat1 && wasInt2 {
-		if math.Abs(float64(f1)-float64(i2)) < 0.5 {
-			return PTBool(true), ""
+	}
+	return true
+}
+
+func equal(args ...Expression) (Expression, error) {
+	if len(args) != 2 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 2 arguments.")
+	}
+	return PTBool(isEqual(args[0], args[1])), nil
+}
+
+func isEmpty(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+
+	arg, ok := args[0].(*SexpPair)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only check if a list is empty.")
+	}
+
+	return PTBool(arg == EmptyList), nil
+}
+
+func lessThan(args ...Expression) (Expression, error) {
+	cmp, ok := compareNums(args[0], args[1])
+	if !ok {
+		return nil, errorf(TypeError, "Invalid types to compare. Each must be int or float.")
+	}
+
+	return PTBool(cmp < 0), nil
+}
+
+func car(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+
+	lst, ok := args[0].(*SexpPair)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only take the car of a list.")
+	}
+	if lst == EmptyList {
+		return nil, errorf(ValueError, "Cannot take the car of an empty list.")
+	}
+
+	return lst.val, nil
+}
+
+func comeFromBehind(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+
+	lst, ok := args[0].(*SexpPair)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only take the cdr of a list.")
+	}
+	if lst == EmptyList {
+		return nil, errorf(ValueError, "Cannot take the cdr of an empty list.")
+	}
+
+	return lst.next, nil
+}
+
+func cons(args ...Expression) (Expression, error) {
+	if len(args) != 2 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 2 arguments.")
+	}
+
+	retVal := &SexpPair{args[0], args[1], true, nil, nil}
+	SetIsLiteral(retVal, true)
+	return retVal, nil
+}
+
+func isPair(args ...Expression) (Expression, error) {
+	_, wasPair := args[0].(*SexpPair)
+
+	return PTBool(wasPair), nil
+}
+
+func youFolks(args ...Expression) (Expression, error) {
+	var head *SexpPair = EmptyList
+
+	for i := len(args) - 1; i >= 0; i-- {
+		head = &SexpPair{args[i], head, true, nil, nil}
+	}
+
+	return head, nil
+}
+
+func isString(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+
+	_, wasString := args[0].(PTString)
+	return PTBool(wasString), nil
+}
+
+func stringAppend(args ...Expression) (Expression, error) {
+	var result strings.Builder
+	for _, arg := range args {
+		str, ok := arg.(PTString)
+		if !ok {
+			return nil, errorf(TypeError, "Invalid types to append. Must all be string.")
 		}
-	} else if wasFloat1 && wasFloat2 {
-		if math.Abs(float64(f1)-float64(f2)) < 0.5 {
-			return PTBool(true), ""
+		result.WriteString(string(str))
+	}
+
+	return PTString(result.String()), nil
+}
+
+func stringLength(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+
+	str, ok := args[0].(PTString)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only take the length of a string.")
+	}
+
+	return PTInt(utf8.RuneCountInString(string(str))), nil
+}
+
+func substring(args ...Expression) (Expression, error) {
+	if len(args) != 2 && len(args) != 3 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting a string, a start index and an optional end index.")
+	}
+
+	str, ok := args[0].(PTString)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only take a substring of a string.")
+	}
+	runes := []rune(string(str))
+
+	start, startOk := args[1].(PTInt)
+	end := PTInt(len(runes))
+	endOk := true
+	if len(args) == 3 {
+		end, endOk = args[2].(PTInt)
+	}
+	if !startOk || !endOk {
+		return nil, errorf(TypeError, "Invalid types for substring indices. Must be int.")
+	}
+
+	if start < 0 || end > PTInt(len(runes)) || start > end {
+		return nil, errorf(ValueError, "Substring indices %d and %d out of range for string of length %d.", start, end, len(runes))
+	}
+
+	return PTString(runes[start:end]), nil
+}
+
+func stringSplit(args ...Expression) (Expression, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting a string and an optional separator.")
+	}
+
+	str, ok := args[0].(PTString)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only split a string.")
+	}
+
+	var pieces []string
+	if len(args) == 2 {
+		sep, sepOk := args[1].(PTString)
+		if !sepOk {
+			return nil, errorf(TypeError, "Invalid type. Separator must be a string.")
 		}
+		pieces = strings.Split(string(str), string(sep))
+	} else {
+		pieces = strings.Fields(string(str))
+	}
+
+	items := make([]Expression, len(pieces))
+	for i, piece := range pieces {
+		items[i] = PTString(piece)
 	}
 
-	return PTBool(false), ""
+	return toList(items...), nil
 }
 
-func readLine(args ...Expression) (Expression, string) {
-	in := bufio.NewReader(os.Stdin)
-	line, err := in.ReadString('\n')
+func stringUpcase(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
 
-	if err != nil {
-		return nil, err.Error()
+	str, ok := args[0].(PTString)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only upcase a string.")
+	}
+
+	return PTString(strings.ToUpper(string(str))), nil
+}
+
+func stringDowncase(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+
+	str, ok := args[0].(PTString)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only downcase a string.")
 	}
 
-	return QuotedSymbol(line), ""
+	return PTString(strings.ToLower(string(str))), nil
 }
 
-func equals(args ...Expression) (Expression, string) {
-	return PTBool(args[0] == args[1]), ""
+func stringLessThan(args ...Expression) (Expression, error) {
+	if len(args) != 2 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 2 arguments.")
+	}
+
+	a, aok := args[0].(PTString)
+	b, bok := args[1].(PTString)
+	if !aok || !bok {
+		return nil, errorf(TypeError, "Invalid types to compare. Must be string and string.")
+	}
+
+	return PTBool(a < b), nil
 }
 
-func isEmpty(args ...Expression) (Expression, string) {
+func stringToSymbol(args ...Expression) (Expression, error) {
 	if len(args) != 1 {
-		return nil, "Invalid arguments. Expecting exactly 1 argument."
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
 	}
 
-	arg, ok := args[0].(*SexpPair)
+	str, ok := args[0].(PTString)
 	if !ok {
-		return nil, "Invalid type. Can only check if a list is empty."
+		return nil, errorf(TypeError, "Invalid type. Can only convert a string to a symbol.")
 	}
 
-	return PTBool(arg == EmptyList), ""
+	return QuotedSymbol(str), nil
 }
 
-func lessThan(args ...Expression) (Expression, string) {
-	i1, wasInt1 := args[0].(PTInt)
-	f1, wasFloat1 := args[0].(PTFloat)
+func symbolToString(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+
+	sym, ok := args[0].(QuotedSymbol)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only convert a symbol to a string.")
+	}
+
+	return PTString(sym), nil
+}
 
-	i2, wasInt2 := args[1].(PTInt)
-	f2, wasFloat2 := args[1].(PTFloat)
+func stringToNumber(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
 
-	if (!wasInt1 && !wasFloat1) || (!wasInt2 && !wasFloat2) {
-		return nil, "Invalid types to compare. Each must be int or float."
+	str, ok := args[0].(PTString)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only convert a string to a number.")
 	}
 
-	if wasInt1 {
-		f1 = PTFloat(i1)
+	// Anything that doesn't read as a number gives #f, as in Scheme
+	switch num := Atomize(strings.TrimSpace(string(str))).(type) {
+	case PTInt, PTBigInt, PTRational, PTFloat:
+		return num, nil
 	}
-	if wasInt2 {
-		f2 = PTFloat(i2)
+	return PTBool(false), nil
+}
+
+func numberToString(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
 	}
 
-	return PTBool(f1 < f2), ""
+	if isNumber(args[0]) {
+		return PTString(args[0].String()), nil
+	}
+	return nil, errorf(TypeError, "Invalid type. Can only convert a number to a string.")
 }
 
-func car(args ...Expression) (Expression, string) {
+func raise(args ...Expression) (Expression, error) {
 	if len(args) != 1 {
-		return nil, "Invalid arguments. Expecting exactly 1 argument."
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
 	}
+	return nil, raisedError(args[0], false)
+}
 
-	if args[0] == nil {
-		return nil, "Cannot take the car of an empty list."
+func raiseContinuable(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
 	}
+	return nil, raisedError(args[0], true)
+}
 
-	lst, ok := args[0].(*SexpPair)
+func signalError(args ...Expression) (Expression, error) {
+	if len(args) < 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting a message and any number of irritants.")
+	}
+	message, ok := args[0].(PTString)
 	if !ok {
-		return nil, "Invalid type. Can only take the car of a list."
+		return nil, errorf(TypeError, "Invalid type. Error message must be a string.")
+	}
+
+	obj := &ErrorObject{Message: string(message), Irritants: append([]Expression{}, args[1:]...)}
+	parts := []string{obj.Message}
+	for _, irritant := range obj.Irritants {
+		parts = append(parts, elementToString(irritant))
+	}
+	obj.Err = &EvalError{Kind: UserError, Message: strings.Join(parts, " "), Raised: obj}
+	return nil, obj.Err
+}
+
+func isErrorObject(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
 	}
+	_, ok := args[0].(*ErrorObject)
+	return PTBool(ok), nil
+}
 
-	return lst.val, ""
+func errorObjectMessage(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+	obj, ok := args[0].(*ErrorObject)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only take the message of an error object.")
+	}
+	return PTString(obj.Message), nil
 }
 
-func comeFromBehind(args ...Expression) (Expression, string) {
+func errorObjectIrritants(args ...Expression) (Expression, error) {
 	if len(args) != 1 {
-		return nil, "Invalid arguments. Expecting exactly 1 argument."
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
 	}
+	obj, ok := args[0].(*ErrorObject)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only take the irritants of an error object.")
+	}
+	return toList(obj.Irritants...), nil
+}
 
-	if args[0] == nil {
-		return nil, "Cannot take the cdr of an empty list."
+func values(args ...Expression) (Expression, error) {
+	if len(args) == 1 {
+		return args[0], nil
 	}
+	return &PTValues{append([]Expression{}, args...)}, nil
+}
 
-	lst, ok := args[0].(*SexpPair)
+// vectorIndex checks that an argument is a vector and another a valid index into it.
+func vectorIndex(vecArg, indexArg Expression) (*PTVector, int, error) {
+	vec, ok := vecArg.(*PTVector)
+	if !ok {
+		return nil, 0, errorf(TypeError, "Invalid type. Must be a vector.")
+	}
+	index, ok := indexArg.(PTInt)
 	if !ok {
-		return nil, "Invalid type. Can only take the cdr of a list."
+		return nil, 0, errorf(TypeError, "Invalid type for vector index. Must be int.")
+	}
+	if index < 0 || int(index) >= len(vec.Items) {
+		return nil, 0, errorf(ValueError, "Vector index %d out of range for vector of length %d.", index, len(vec.Items))
+	}
+	return vec, int(index), nil
+}
+
+func vector(args ...Expression) (Expression, error) {
+	return &PTVector{Items: append([]Expression{}, args...)}, nil
+}
+
+func makeVector(args ...Expression) (Expression, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting a length and an optional fill value.")
+	}
+	length, ok := args[0].(PTInt)
+	if !ok || length < 0 {
+		return nil, errorf(TypeError, "Invalid type. Vector length must be a non-negative int.")
+	}
+
+	var fill Expression = PTBool(false)
+	if len(args) == 2 {
+		fill = args[1]
+	}
+	items := make([]Expression, length)
+	for i := range items {
+		items[i] = fill
 	}
+	return &PTVector{Items: items}, nil
+}
 
-	return lst.next, ""
+func isVector(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+	_, ok := args[0].(*PTVector)
+	return PTBool(ok), nil
 }
 
-func cons(args ...Expression) (Expression, string) {
+func vectorRef(args ...Expression) (Expression, error) {
 	if len(args) != 2 {
-		return nil, "Invalid arguments. Expecting exactly 2 arguments."
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 2 arguments.")
+	}
+	vec, index, err := vectorIndex(args[0], args[1])
+	if err != nil {
+		return nil, err
+	}
+	return vec.Items[index], nil
+}
+
+func vectorSet(args ...Expression) (Expression, error) {
+	if len(args) != 3 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 3 arguments.")
+	}
+	vec, index, err := vectorIndex(args[0], args[1])
+	if err != nil {
+		return nil, err
 	}
+	if vec.constant {
+		return nil, errorf(ValueError, "Vector literals are constants, so they can't be changed.")
+	}
+	vec.Items[index] = args[2]
+	return PTBlank, nil
+}
 
-	head := args[0]
-	lst, ok := args[1].(*SexpPair)
+func vectorLength(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+	vec, ok := args[0].(*PTVector)
 	if !ok {
-		return nil, "Cannot cons to a non-list."
+		return nil, errorf(TypeError, "Invalid type. Can only take the length of a vector.")
 	}
+	return PTInt(len(vec.Items)), nil
+}
 
-	retVal := &SexpPair{head, lst, true}
-	SetIsLiteral(retVal, true)
-	return retVal, ""
+func vectorToList(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+	vec, ok := args[0].(*PTVector)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only convert a vector to a list.")
+	}
+	return toList(vec.Items...), nil
 }
 
-func isPair(args ...Expression) (Expression, string) {
-	_, wasPair := args[0].(*SexpPair)
+func listToVector(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+	lst, ok := args[0].(*SexpPair)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only convert a list to a vector.")
+	}
+	items, err := ToSlice(lst)
+	if err != nil {
+		return nil, errorf(TypeError, "Invalid type. Can only convert a proper list to a vector.")
+	}
+	return &PTVector{Items: items}, nil
+}
 
-	return PTBool(wasPair), ""
+func vectorFill(args ...Expression) (Expression, error) {
+	if len(args) != 2 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 2 arguments.")
+	}
+	vec, ok := args[0].(*PTVector)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only fill a vector.")
+	}
+	if vec.constant {
+		return nil, errorf(ValueError, "Vector literals are constants, so they can't be changed.")
+	}
+	for i := range vec.Items {
+		vec.Items[i] = args[1]
+	}
+	return PTBlank, nil
 }
 
-func youFolks(args ...Expression) (Expression, string) {
-	var head *SexpPair = EmptyList
+func makeHashTable(args ...Expression) (Expression, error) {
+	if len(args) != 0 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting no arguments.")
+	}
+	return NewHashTable(false), nil
+}
 
-	for i := len(args) - 1; i >= 0; i-- {
-		head = &SexpPair{args[i], head, true}
-	}
-
-	return head, ""
-}
-
-var goLibraryProcs map[string]goProcPtr = map[string]goProcPtr{
-	"+":                add,
-	"-":                subtract,
-	"*":                multiply,
-	"/":                divide,
-	"%":                mod,
-	"sqrt":             sqrt,
-	"or":               or,
-	"and":              and,
-	"not":              not,
-	"eq?":              equals,
-	"most-probably?":   mostProbably,
-	"empty?":           isEmpty,
-	"one-less-car":     car,
-	"come-from-behind": comeFromBehind,
-	"cons":             cons,
-	"pair?":            isPair,
-	"you-folks":        youFolks,
-	"<":                lessThan,
-	"readln":           readLine,
+func makeEqHashTable(args ...Expression) (Expression, error) {
+	if len(args) != 0 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting no arguments.")
+	}
+	return NewHashTable(true), nil
+}
+
+func isHashTable(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+	_, ok := args[0].(*PTHashTable)
+	return PTBool(ok), nil
+}
+
+// hashTableArgs checks that a procedure was given a hash table followed by the given number of other arguments.
+func hashTableArgs(args []Expression, numOthers int) (*PTHashTable, error) {
+	if len(args) != numOthers+1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly %d arguments.", numOthers+1)
+	}
+	table, ok := args[0].(*PTHashTable)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Must be a hash table.")
+	}
+	return table, nil
+}
+
+func hashTableRefDefault(args ...Expression) (Expression, error) {
+	table, err := hashTableArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	value, found, err := table.Get(args[1])
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return args[2], nil
+	}
+	return value, nil
+}
+
+func hashTableContains(args ...Expression) (Expression, error) {
+	table, err := hashTableArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	_, found, err := table.Get(args[1])
+	return PTBool(found), err
+}
+
+func hashTableSet(args ...Expression) (Expression, error) {
+	table, err := hashTableArgs(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	if err := table.Set(args[1], args[2]); err != nil {
+		return nil, err
+	}
+	return PTBlank, nil
+}
+
+func hashTableDelete(args ...Expression) (Expression, error) {
+	table, err := hashTableArgs(args, 1)
+	if err != nil {
+		return nil, err
+	}
+	if err := table.Delete(args[1]); err != nil {
+		return nil, err
+	}
+	return PTBlank, nil
+}
+
+func hashTableCount(args ...Expression) (Expression, error) {
+	table, err := hashTableArgs(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	return PTInt(len(table.entries)), nil
+}
+
+func hashTableKeys(args ...Expression) (Expression, error) {
+	table, err := hashTableArgs(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	var keys []Expression
+	for _, entry := range table.Entries() {
+		keys = append(keys, entry.Key)
+	}
+	return toList(keys...), nil
+}
+
+func hashTableValues(args ...Expression) (Expression, error) {
+	table, err := hashTableArgs(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	var vals []Expression
+	for _, entry := range table.Entries() {
+		vals = append(vals, entry.Value)
+	}
+	return toList(vals...), nil
+}
+
+func hashTableToAlist(args ...Expression) (Expression, error) {
+	table, err := hashTableArgs(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	var pairs []Expression
+	for _, entry := range table.Entries() {
+		pairs = append(pairs, &SexpPair{entry.Key, entry.Value, true, nil, nil})
+	}
+	return toList(pairs...), nil
+}
+
+func makePromise(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+	if promise, ok := args[0].(*Promise); ok {
+		return promise, nil
+	}
+	return &Promise{&promiseState{Done: true, Value: args[0]}}, nil
+}
+
+func isPromise(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+	_, ok := args[0].(*Promise)
+	return PTBool(ok), nil
+}
+
+// goLibraryProcs makes the table of library functions written in go.
+// It's made afresh for each global Env, so no interpreter can change what another starts with.
+func goLibraryProcs() map[string]goProcPtr {
+	return map[string]goProcPtr{
+		"+":                      add,
+		"-":                      subtract,
+		"*":                      multiply,
+		"/":                      divide,
+		"%":                      mod,
+		"quotient":               quotient,
+		"numerator":              numerator,
+		"denominator":            denominator,
+		"exact->inexact":         exactToInexact,
+		"inexact->exact":         inexactToExact,
+		"sqrt":                   sqrt,
+		"or":                     or,
+		"and":                    and,
+		"not":                    not,
+		"eq?":                    equals,
+		"equal?":                 equal,
+		"most-probably?":         mostProbably,
+		"empty?":                 isEmpty,
+		"one-less-car":           car,
+		"come-from-behind":       comeFromBehind,
+		"cons":                   cons,
+		"pair?":                  isPair,
+		"you-folks":              youFolks,
+		"<":                      lessThan,
+		"readln":                 readLine,
+		"string?":                isString,
+		"string-append":          stringAppend,
+		"string-length":          stringLength,
+		"substring":              substring,
+		"string-split":           stringSplit,
+		"string-upcase":          stringUpcase,
+		"string-downcase":        stringDowncase,
+		"string<?":               stringLessThan,
+		"string->symbol":         stringToSymbol,
+		"symbol->string":         symbolToString,
+		"string->number":         stringToNumber,
+		"number->string":         numberToString,
+		"raise":                  raise,
+		"raise-continuable":      raiseContinuable,
+		"error":                  signalError,
+		"error-object?":          isErrorObject,
+		"error-object-message":   errorObjectMessage,
+		"error-object-irritants": errorObjectIrritants,
+		"values":                 values,
+		"vector":                 vector,
+		"make-vector":            makeVector,
+		"vector?":                isVector,
+		"vector-ref":             vectorRef,
+		"vector-set!":            vectorSet,
+		"vector-length":          vectorLength,
+		"vector->list":           vectorToList,
+		"list->vector":           listToVector,
+		"vector-fill!":           vectorFill,
+		"make-hash-table":        makeHashTable,
+		"make-eq-hash-table":     makeEqHashTable,
+		"hash-table?":            isHashTable,
+		"hash-table-ref/default": hashTableRefDefault,
+		"hash-table-contains?":   hashTableContains,
+		"hash-table-set!":        hashTableSet,
+		"hash-table-delete!":     hashTableDelete,
+		"hash-table-count":       hashTableCount,
+		"hash-table-keys":        hashTableKeys,
+		"hash-table-values":      hashTableValues,
+		"hash-table->alist":      hashTableToAlist,
+		"make-promise":           makePromise,
+		"promise?":               isPromise,
+		"output-port?":           isOutputPort,
+		"open-output-string":     openOutputString,
+		"get-output-string":      getOutputString,
+	}
+}
+
+func isOutputPort(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+	_, ok := args[0].(*PTPort)
+	return PTBool(ok), nil
+}
+
+func openOutputString(args ...Expression) (Expression, error) {
+	if len(args) != 0 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting no arguments.")
+	}
+	return NewStringPort(), nil
+}
+
+func getOutputString(args ...Expression) (Expression, error) {
+	if len(args) != 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+	}
+	port, ok := args[0].(*PTPort)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Expecting a port.")
+	}
+	collected, ok := port.Writer.(*strings.Builder)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Can only get the output of a port made by open-output-string.")
+	}
+	return PTString(collected.String()), nil
 }
 
 var alternateNames map[string]string = map[string]string{
 	"car":  "one-less-car",
 	"cdr":  "come-from-behind",
-	"list": "you-olks",
+	"list": "you-folks",
 	"fact": "in-fact",
 }
 
@@ -479,6 +1113,18 @@ const libraryCode = `
 	)
 )
 
+(yknow vector-map
+	(bring-me-back-something-good (func vec)
+		(let ((result (make-vector (vector-length vec))))
+			(let loop ((i 0))
+				(insofaras (< i (vector-length vec))
+					(begin
+						(vector-set! result i (func (vector-ref vec i)))
+						(loop (+ i 1)))
+					result)))
+	)
+)
+
 (yknow foldl
 	(bring-me-back-something-good (func start lst)
 		(cond
@@ -492,8 +1138,8 @@ const libraryCode = `
 	(bring-me-back-something-good (x n)
 		(cond
 			((eq? n 0) 1)
-			((eq? (% n 2) 0) (pow (* x x) (/ n 2)))
-			(#t (* x (pow (* x x) (/ (- n 1) 2))))
+			((eq? (% n 2) 0) (pow (* x x) (quotient n 2)))
+			(#t (* x (pow (* x x) (quotient (- n 1) 2))))
 		)
 	)
 )
@@ -504,9 +1150,9 @@ const libraryCode = `
 			((eq? n 0)
 				1)
 			((eq? (% n 2) 0)
-				(% (powmod (% (* x x) m) (/ n 2) m) m))
+				(% (powmod (% (* x x) m) (quotient n 2) m) m))
 			(#t
-				(% (* x (powmod (% (* x x) m) (/ (- n 1) 2) m)) m))
+				(% (* x (powmod (% (* x x) m) (quotient (- n 1) 2) m)) m))
 		)
 	)
 )
@@ -535,8 +1181,8 @@ const libraryCode = `
 (yknow split
 	(bring-me-back-something-good (lst)
 		(you-folks
-			(slice-left lst (/ (len lst) 2))
-			(slice-right lst (/ (len lst) 2))
+			(slice-left lst (quotient (len lst) 2))
+			(slice-right lst (quotient (len lst) 2))
 		)
 	)
 )
@@ -561,8 +1207,8 @@ const libraryCode = `
 		(insofaras (< (len lst) 2)
 			lst
 			(let (
-				(left-half (slice-left lst (/ (len lst) 2)))
-				(right-half (slice-right lst (/ (len lst) 2))))
+				(left-half (slice-left lst (quotient (len lst) 2)))
+				(right-half (slice-right lst (quotient (len lst) 2))))
 			(merge (merge-sort left-half) (merge-sort right-half)))
 		)
 	)
@@ -618,14 +1264,10 @@ const libraryCode = `
 
 (yknow reverse
 	(bring-me-back-something-good (lst)
-		(let (
-			(reverse-helper (bring-me-back-something-good (lst work)
-				(cond
-					((empty? lst) work)
-					(#t (reverse-helper (come-from-behind lst) (cons (one-less-car lst) work)))))))
-
-			(reverse-helper lst '())
-		)
+		(let loop ((lst lst) (work '()))
+			(cond
+				((empty? lst) work)
+				(#t (loop (come-from-behind lst) (cons (one-less-car lst) work)))))
 	)
 )
 
@@ -639,23 +1281,19 @@ const libraryCode = `
 )
 
 (yknow append
-	(bring-me-back-something-good (lst1 lst2)
-		(let (
-			(append-elem (bring-me-back-something-good (lst elem)
-				(cond
-					((empty? lst)
-						(you-folks elem))
-					(#t
-						(cons (one-less-car lst) (append-elem (come-from-behind lst) elem)))))))
+	(bring-me-back-something-good lsts
+		(letrec (
+			(append-two (bring-me-back-something-good (lst1 lst2)
+				(insofaras (empty? lst1)
+					lst2
+					(cons (one-less-car lst1) (append-two (come-from-behind lst1) lst2))))))
 		(cond
-			((empty? lst2)
-				lst1)
+			((empty? lsts)
+				'())
+			((empty? (come-from-behind lsts))
+				(one-less-car lsts))
 			(#t
-				(append
-					(append-elem lst1 (car lst2))
-					(come-from-behind lst2)
-				)
-			)
+				(append-two (one-less-car lsts) (crunch-crunch-crunch append (come-from-behind lsts))))
 		))
 	)
 )
@@ -677,4 +1315,106 @@ const libraryCode = `
 		)
 	)
 )
+
+(define-syntax when
+	(syntax-rules ()
+		((_ test body ...)
+			(insofaras test (begin body ...) #f))
+	)
+)
+
+(define-syntax unless
+	(syntax-rules ()
+		((_ test body ...)
+			(insofaras test #f (begin body ...)))
+	)
+)
+
+(define-syntax while
+	(syntax-rules ()
+		((_ test body ...)
+			(let loop ()
+				(insofaras test
+					(begin body ... (loop))
+					#f)))
+	)
+)
+
+(define-syntax unwind-protect
+	(syntax-rules ()
+		((_ protected cleanup ...)
+			(dynamic-wind
+				(lambda () #f)
+				(lambda () protected)
+				(lambda () cleanup ...)))
+	)
+)
+
+(define-syntax receive
+	(syntax-rules ()
+		((_ formals expr body ...)
+			(let-values ((formals expr)) body ...))
+	)
+)
+
+(define (with-cleanup thunk cleanup)
+	(dynamic-wind (lambda () #f) thunk cleanup))
+
+(define-syntax stream-cons
+	(syntax-rules ()
+		((_ head tail)
+			(delay (cons head (delay-force tail))))
+	)
+)
+
+(yknow stream-null (make-promise '()))
+
+(define (stream-null? s)
+	(empty? (force s)))
+
+(define (stream-car s)
+	(one-less-car (force s)))
+
+(define (stream-cdr s)
+	(come-from-behind (force s)))
+
+(define (stream-take s n)
+	(let loop ((s s) (n n) (taken '()))
+		(insofaras (<= n 0)
+			(reverse taken)
+			(let ((cell (force s)))
+				(insofaras (empty? cell)
+					(reverse taken)
+					(loop (come-from-behind cell) (- n 1) (cons (one-less-car cell) taken)))))))
+
+(define (stream-range a b)
+	(insofaras (< a b)
+		(stream-cons a (stream-range (+ a 1) b))
+		stream-null))
+
+(define (stream-repeat val)
+	(stream-cons val (stream-repeat val)))
+
+(define (hash-table-ref table key #!optional fail)
+	(cond
+		((hash-table-contains? table key)
+			(hash-table-ref/default table key #f))
+		((eq? fail #f)
+			(error "Key not found in hash table:" key))
+		(#t
+			(fail))))
+
+(define (hash-table-update! table key func #!optional fail)
+	(hash-table-set! table key (func (hash-table-ref table key fail))))
+
+(define (hash-table-update!/default table key func default)
+	(hash-table-set! table key (func (hash-table-ref/default table key default))))
+
+(define (hash-table-walk table func)
+	(let loop ((entries (hash-table->alist table)))
+		(insofaras (empty? entries)
+			(begin)
+			(begin
+				(func (one-less-car (one-less-car entries)) (come-from-behind (one-less-car entries)))
+				(loop (come-from-behind entries))))))
 `
@@ -0,0 +1,141 @@
+// Command golftalk is a REPL for golftalk.
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/bitbanger/golftalk"
+)
+
+// profiledExpr picks out the expression from a (profile expr) typed at the REPL.
+// It's handled here rather than as a special form, so that nothing evaluated can start an evaluation of its own.
+func profiledExpr(sexp golftalk.Expression) (golftalk.Expression, bool) {
+	call, ok := sexp.(*golftalk.SexpPair)
+	if !ok || call == golftalk.EmptyList {
+		return nil, false
+	}
+	items, err := golftalk.ToSlice(call)
+	if err != nil || len(items) != 2 || items[0] != golftalk.Symbol("profile") {
+		return nil, false
+	}
+	return items[1], true
+}
+
+// writeProfile writes what profiler saw to the named file, for go tool pprof.
+func writeProfile(path string, profiler *golftalk.Profiler) {
+	out, err := os.Create(path)
+	if err == nil {
+		err = profiler.WritePprof(out)
+		if closeErr := out.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Couldn't write profile: %s\n", err)
+	}
+}
+
+func main() {
+	cpuprofile := flag.String("cpuprofile", "", "write a pprof profile of everything evaluated to `file` on exit")
+	flag.Parse()
+
+	in := bufio.NewReader(os.Stdin)
+	debugger := golftalk.NewDebugger(in, os.Stdout)
+	interp := golftalk.New(golftalk.WithDebugger(debugger))
+
+	exit := func(newlines string) {
+		fmt.Println(newlines + "have a nice day ;)")
+	}
+	if *cpuprofile != "" {
+		profiler := golftalk.NewProfiler()
+		interp.Profile(profiler)
+		exit = func(newlines string) {
+			writeProfile(*cpuprofile, profiler)
+			fmt.Println(newlines + "have a nice day ;)")
+		}
+	}
+
+	for {
+		fmt.Print("golftalk~$ ")
+		line, err := in.ReadString('\n')
+
+		if err != nil {
+			if err == io.EOF {
+				exit("\n\n")
+				break
+			} else {
+				panic(err)
+			}
+		}
+
+		stepping := false
+		if strings.HasPrefix(line, ",") {
+			// REPL commands look like unquotes, which are meaningless at the top level anyway
+			command := strings.Fields(line[1:])
+			if len(command) == 2 && (command[0] == "break" || command[0] == "delete") {
+				if command[0] == "break" {
+					debugger.Breakpoints[command[1]] = true
+				} else {
+					delete(debugger.Breakpoints, command[1])
+				}
+				continue
+			}
+			if len(command) == 0 || command[0] != "step" {
+				fmt.Println("No.\n\tREPL commands are ,break NAME  ,delete NAME  ,step EXPR")
+				continue
+			}
+			// Blank out the command so positions in the expression are still counted from the start of the line
+			end := strings.Index(line, "step") + len("step")
+			line = strings.Repeat(" ", end) + line[end:]
+			stepping = true
+		}
+
+		if line != "" && line != "\n" {
+			sexps, parseErr := golftalk.ParseLine(line)
+			if parseErr != nil {
+				fmt.Printf("No.\n\t%s\n", parseErr.Error())
+				continue
+			}
+
+			for _, sexp := range sexps {
+				if stepping {
+					debugger.Pause()
+				}
+				profiled, profiling := profiledExpr(sexp)
+				var result golftalk.Expression
+				if profiling {
+					profiler := golftalk.NewProfiler()
+					stop := interp.Profile(profiler)
+					result, err = interp.Eval(profiled)
+					stop()
+					profiler.Report(os.Stdout)
+				} else {
+					result, err = interp.Eval(sexp)
+				}
+
+				if errors.Is(err, golftalk.ErrExit) {
+					exit("\n")
+					return
+				}
+				var evalErr *golftalk.EvalError
+				if errors.As(err, &evalErr) {
+					fmt.Printf("No.\n\t%s\n", strings.Replace(evalErr.Traceback(), "\n", "\n\t", -1))
+					continue
+				}
+
+				if result != nil {
+					// Multiple values go one per line
+					for _, val := range golftalk.Values(result) {
+						fmt.Println(golftalk.Repr(val))
+					}
+				}
+			}
+		}
+	}
+}
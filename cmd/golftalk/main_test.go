@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bitbanger/golftalk"
+)
+
+func TestProfiledExpr(t *testing.T) {
+	for expr, expect := range map[string]string{"(profile (loop 3))": "(loop 3)", "(profile)": "", "'(profile 1)": "", "(profile 1 2)": ""} {
+		sexps, _ := golftalk.ParseLine(expr)
+		profiled, ok := profiledExpr(sexps[0])
+		if ok != (expect != "") || ok && golftalk.SexpToString(profiled) != expect {
+			t.Errorf("%s is profiling %v, %v, want %q\n", expr, profiled, ok, expect)
+		}
+	}
+}
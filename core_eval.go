@@ -1,275 +1,663 @@
-package main
+package golftalk
 
 import (
 	"errors"
 	"fmt"
-	"os"
 )
 
-type CoreFunc func(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, done bool, err string)
+type CoreFunc func(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, done bool, err error)
 
-func coreCallCC(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, done bool, err string) {
-	return nil, nil, true, "call/cc not implemented"
-	//FIXME: actually do this
-	/*
-		args, _ := lst.next.(*SexpPair)
-
-		arg := Get(args, 0)
-		evalArg, evalErr := Eval(arg, env)
+func coreCallCC(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	args, env := frame.Args, frame.CurrentEnv
+	frame.Step++
 
-		if evalErr != "" {
-			return nil, nil, evalErr
+	switch frame.Step {
+	case 1:
+		if length, _ := args.Len(); length != 1 {
+			return nil, nil, true, errorf(SyntaxError, "call/cc takes exactly one argument.")
 		}
-
-		proc, wasProc := evalArg.(Procedure)
-
+		return Get(args, 0), env, false, nil
+	case 2:
+		proc, wasProc := frame.StepInput.(Procedure)
 		if !wasProc {
-			return nil, nil, "Argument to call/cc must be a procedure."
+			return nil, nil, true, errorf(TypeError, "Argument to call/cc must be a procedure.")
 		}
 
-		var throw goProcPtr = func(args ...Expression) (Expression, string) {
-			fmt.Println("NON-LOCAL CONTROL FLOW FTW!")
-
-			return nil, ""
-		}
+		// The continuation is everything waiting beneath this frame
+		cont := &Continuation{(*stack)[:len(*stack)-1].Copy()}
 
-		throwProc := &GoProc{"throw", throw}
-		throwPair := &SexpPair{throwProc, EmptyList, false}
-
-		return proc.Apply(throwPair, env)
-	*/
+		// Call the procedure with the continuation in our place
+		return &SexpPair{proc, &SexpPair{cont, EmptyList, false, nil, nil}, false, nil, nil}, env, true, nil
+	}
+	panic(errors.New(fmt.Sprintf("Invalid step %d in call/cc", frame.Step)))
 }
 
-func coreDefine(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err string) {
+func coreDefine(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
 	args, env := frame.Args, frame.CurrentEnv
 	frame.Step++
 
 	switch frame.Step {
 	case 1:
-		symExp := Get(args, 1)
+		if length, _ := args.Len(); length < 2 {
+			return nil, nil, true, errorf(SyntaxError, "define takes a symbol and a value.")
+		}
+
+		// (define (name . params) body...) is shorthand for defining a lambda
+		if signature, wasPair := args.val.(*SexpPair); wasPair && signature != EmptyList && !signature.literal {
+			sym, wasSym := bindingName(signature.val)
+			if !wasSym {
+				return nil, nil, true, errorf(SyntaxError, "Symbol given to define wasn't a symbol.")
+			}
+
+			proc, err := parseParams(signature.next)
+			if err != nil {
+				return nil, nil, true, err
+			}
+			proc.Exp, err = parseBody(args.next, "define")
+			if err != nil {
+				return nil, nil, true, err
+			}
+			proc.EvalEnv = env
+			proc.GiveName(string(baseSymbol(signature.val)))
 
-		if _, wasSym := Get(args, 0).(Symbol); !wasSym {
-			return nil, nil, true, "Symbol given to define wasn't a symbol."
+			env.Dict[sym] = proc
+			return PTBlank, nil, true, nil
+		}
+
+		if !isIdentifier(Get(args, 0)) {
+			return nil, nil, true, errorf(SyntaxError, "Symbol given to define wasn't a symbol.")
+		}
+		if length, _ := args.Len(); length != 2 {
+			return nil, nil, true, errorf(SyntaxError, "define takes a symbol and a value.")
 		}
 
-		return symExp, env, false, ""
+		return Get(args, 1), env, false, nil
 	case 2:
 		evalExp := frame.StepInput
 
 		// Sym should be ok from previous step
-		sym := args.val.(Symbol)
+		sym, _ := bindingName(args.val)
 
 		// If we're binding a function to this name, make sure the function literal knows what it's called.
 		// This is just to conform with Racket's function display technique. It's not used in the actual execution of the function!
 		if proc, wasProc := evalExp.(Procedure); wasProc {
-			proc.GiveName(string(sym))
+			proc.GiveName(string(baseSymbol(args.val)))
 			env.Dict[sym] = proc
 		} else {
 			env.Dict[sym] = evalExp
 		}
-		return PTBlank, nil, true, ""
+		return PTBlank, nil, true, nil
 	}
 	panic(errors.New(fmt.Sprintf("Invalid step %d in define", frame.Step)))
 }
 
-func coreIf(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err string) {
+func coreSet(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	args, env := frame.Args, frame.CurrentEnv
+	frame.Step++
+
+	switch frame.Step {
+	case 1:
+		if length, _ := args.Len(); length != 2 {
+			return nil, nil, true, errorf(SyntaxError, "set! takes two arguments: a symbol and a value.")
+		}
+
+		target := Get(args, 0)
+		if !isIdentifier(target) {
+			return nil, nil, true, errorf(SyntaxError, "Symbol given to set! wasn't a symbol.")
+		}
+
+		// Fail before evaluating the value if there's nothing to set
+		if _, _, lookupErr := target.Eval(nil, env); lookupErr != nil {
+			return nil, nil, true, lookupErr
+		}
+
+		return Get(args, 1), env, false, nil
+	case 2:
+		// Target should be ok from previous step
+		if setErr := setIdentifier(env, args.val, frame.StepInput); setErr != nil {
+			return nil, nil, true, errorf(UnboundError, "%s", setErr)
+		}
+		return PTBlank, nil, true, nil
+	}
+	panic(errors.New(fmt.Sprintf("Invalid step %d in set!", frame.Step)))
+}
+
+func coreIf(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
 	args, env := frame.Args, frame.CurrentEnv
 	frame.Step++
 
 	switch frame.Step {
 	case 1:
 		test := Get(args, 0)
-		return test, env, false, ""
+		return test, env, false, nil
 	case 2:
 		res, wasBool := frame.StepInput.(PTBool)
 		if !wasBool {
-			return nil, nil, true, "Test given to conditional did not evaluate to a bool."
+			return nil, nil, true, errorf(TypeError, "Test given to conditional did not evaluate to a bool.")
 		}
 
 		if res {
 			conseq := Get(args, 1)
-			return conseq, env, true, ""
+			return conseq, env, true, nil
 		}
 
 		alt := Get(args, 2)
-		return alt, env, true, ""
+		return alt, env, true, nil
 	}
 	panic(errors.New(fmt.Sprintf("Invalid step %d in if", frame.Step)))
 }
 
-func coreLambda(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err string) {
+func coreLambda(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
 	args, env := frame.Args, frame.CurrentEnv
 
-	symbols, symbolsOk := args.val.(*SexpPair)
-	numSymbols, error := symbols.Len()
-	if !symbolsOk || error != nil {
-		return nil, nil, true, "Symbol list to bind within lambda wasn't a list."
+	if args == EmptyList {
+		return nil, nil, true, errorf(SyntaxError, "Symbol list to bind within lambda wasn't a list.")
 	}
 
-	exp := Get(args, 1)
+	proc, err := parseParams(args.val)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	proc.Exp, err = parseBody(args.next, "lambda")
+	if err != nil {
+		return nil, nil, true, err
+	}
+	proc.EvalEnv = env
+
+	return proc, env, true, nil
+}
+
+// parseBody turns the body of a lambda, let or similar form into a single expression.
+// A body of several expressions is evaluated in sequence, as if by begin, so any defines in it are scoped to the body.
+func parseBody(body Expression, form string) (Expression, error) {
+	exprs, wasPair := body.(*SexpPair)
+	if _, lenErr := exprs.Len(); !wasPair || lenErr != nil {
+		return nil, errorf(SyntaxError, "%s: body not a list", form)
+	}
+	if exprs == EmptyList {
+		return nil, errorf(SyntaxError, "%s needs at least one expression in its body.", form)
+	}
+	if exprs.next == EmptyList {
+		return exprs.val, nil
+	}
+	return &SexpPair{CoreFunc(coreBegin), exprs, false, nil, nil}, nil
+}
+
+// parseParams reads a lambda parameter list into a Proc with no body.
+// Required parameters come first, then any after #!optional, which may be given as (name default).
+// A rest parameter may follow #!rest or the dot of an improper list, or be the whole parameter list.
+func parseParams(params Expression) (*Proc, error) {
+	const (
+		required = iota
+		optional
+		rest
+	)
 
-	lambVars := make([]Symbol, numSymbols)
-	for i := range lambVars {
-		lambVar, _ := Get(symbols, i).(Symbol)
-		lambVars[i] = lambVar
+	proc := &Proc{}
+	mode := required
+	for {
+		if name, isName := bindingName(params); isName {
+			// Dotted tail
+			if proc.Rest != "" {
+				return nil, errorf(SyntaxError, "A lambda can have only one rest parameter.")
+			}
+			proc.Rest = name
+			break
+		}
+
+		pair, wasPair := params.(*SexpPair)
+		if !wasPair {
+			return nil, errorf(SyntaxError, "Symbol list to bind within lambda wasn't a list.")
+		}
+		if pair == EmptyList {
+			break
+		}
+		params = pair.next
+
+		param := pair.val
+		if isIdentifier(param) && baseSymbol(param) == "#!optional" {
+			if mode != required {
+				return nil, errorf(SyntaxError, "#!optional must come before any optional or rest parameters.")
+			}
+			mode = optional
+			continue
+		}
+		if isIdentifier(param) && baseSymbol(param) == "#!rest" {
+			if mode == rest {
+				return nil, errorf(SyntaxError, "A lambda can have only one rest parameter.")
+			}
+			mode = rest
+			continue
+		}
+
+		var opt OptionalParam
+		if withDefault, wasPair := param.(*SexpPair); wasPair && mode == optional {
+			if length, _ := withDefault.Len(); length != 2 {
+				return nil, errorf(SyntaxError, "Optional parameters with defaults must be a symbol and a default.")
+			}
+			param = withDefault.val
+			opt.Default = Get(withDefault, 1)
+		}
+
+		name, isName := bindingName(param)
+		if !isName {
+			return nil, errorf(SyntaxError, "Symbol list to bind within lambda contained a non-symbol.")
+		}
+
+		switch mode {
+		case required:
+			proc.Vars = append(proc.Vars, name)
+		case optional:
+			opt.Name = name
+			proc.Optional = append(proc.Optional, opt)
+		case rest:
+			if proc.Rest != "" {
+				return nil, errorf(SyntaxError, "A lambda can have only one rest parameter.")
+			}
+			proc.Rest = name
+		}
+	}
+
+	if mode == rest && proc.Rest == "" {
+		return nil, errorf(SyntaxError, "#!rest must be followed by a parameter.")
+	}
+
+	return proc, nil
+}
+
+func coreCaseLambda(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	args, env := frame.Args, frame.CurrentEnv
+
+	clauses, listErr := ToSlice(args)
+	if listErr != nil {
+		return nil, nil, true, errorf(SyntaxError, "case-lambda: clause list not a list")
+	}
+
+	caseLambda := &CaseLambda{}
+	for i, clause := range clauses {
+		clausePair, wasPair := clause.(*SexpPair)
+		if length, _ := clausePair.Len(); !wasPair || length < 2 {
+			return nil, nil, true, errorf(SyntaxError, "case-lambda clause #%d must be a parameter list and a body.", i+1)
+		}
+
+		proc, err := parseParams(clausePair.val)
+		if err != nil {
+			return nil, nil, true, err
+		}
+		proc.Exp, err = parseBody(clausePair.next, "case-lambda")
+		if err != nil {
+			return nil, nil, true, err
+		}
+		proc.EvalEnv = env
+		caseLambda.Clauses = append(caseLambda.Clauses, proc)
 	}
 
-	return &Proc{"", lambVars, exp, env}, env, true, ""
+	return caseLambda, env, true, nil
 }
 
-func coreQuote(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err string) {
+func coreQuote(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
 	args, env := frame.Args, frame.CurrentEnv
 
 	if args == EmptyList {
-		return nil, nil, true, "Need something to quote."
+		return nil, nil, true, errorf(SyntaxError, "Need something to quote.")
 	}
 	if args.next != EmptyList {
-		return nil, nil, true, "Too many arguments to quote."
+		return nil, nil, true, errorf(SyntaxError, "Too many arguments to quote.")
 	}
 
-	if argLst, ok := args.val.(*SexpPair); ok {
-		SetIsLiteral(argLst, true)
-		return argLst, env, true, ""
-	}
+	return quoteDatum(args.val), env, true, nil
+}
 
-	return args.val, env, true, ""
+// quoteDatum turns code into data: symbols become quoted symbols, and lists are copied into literal lists.
+func quoteDatum(expr Expression) Expression {
+	switch datum := expr.(type) {
+	case Symbol, *RenamedSymbol:
+		return QuotedSymbol(baseSymbol(datum))
+	case *SexpPair:
+		if datum == EmptyList {
+			return datum
+		}
+		return &SexpPair{quoteDatum(datum.val), quoteDatum(datum.next), true, datum.pos, datum.open}
+	}
+	return expr
 }
 
-func coreApply(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err string) {
+func coreQuasiquote(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
 	args, env := frame.Args, frame.CurrentEnv
-	frame.Step++
 
-	switch frame.Step {
-	case 1:
-		return Get(args, 0), env, false, ""
-	case 2:
-		evalFunc := frame.StepInput
-		proc, wasFunc := evalFunc.(Procedure)
-		if !wasFunc {
-			return nil, nil, true, "Function given to apply doesn't evaluate as a function."
+	if length, _ := args.Len(); length != 1 {
+		return nil, nil, true, errorf(SyntaxError, "quasiquote takes exactly one argument.")
+	}
+
+	// Evaluate an expression that builds the structure with its holes filled in
+	builder, err := quasiBuilder(args.val, 0)
+	if err != nil {
+		return nil, nil, true, err
+	}
+	return builder, env, true, nil
+}
+
+func coreUnquote(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	return nil, nil, true, errorf(SyntaxError, "unquote is only allowed within quasiquote.")
+}
+
+var quasiCons = &GoProc{"cons", cons}
+var quasiSplice = &GoProc{"unquote-splicing", spliceList}
+var quasiList = &GoProc{"you-folks", youFolks}
+
+// spliceList appends a list onto the front of another, copying it so the result can be changed freely.
+func spliceList(args ...Expression) (Expression, error) {
+	lst, wasList := args[0].(*SexpPair)
+	items, listErr := ToSlice(lst)
+	if !wasList || listErr != nil {
+		return nil, errorf(TypeError, "unquote-splicing: value to splice is not a list.")
+	}
+
+	var result Expression = args[1]
+	for i := len(items) - 1; i >= 0; i-- {
+		result = &SexpPair{items[i], result, true, nil, nil}
+	}
+	return result, nil
+}
+
+// isQuasiForm reports whether expr is a form like (unquote x), returning x.
+func isQuasiForm(expr Expression, name Symbol) (arg Expression, ok bool) {
+	pair, wasPair := expr.(*SexpPair)
+	if !wasPair || pair == EmptyList || pair.literal || !isIdentifier(pair.val) || baseSymbol(pair.val) != name {
+		return nil, false
+	}
+	rest, wasPair := pair.next.(*SexpPair)
+	if !wasPair || rest == EmptyList || rest.next != EmptyList {
+		return nil, false
+	}
+	return rest.val, true
+}
+
+// quasiBuilder translates a quasiquoted template into an expression that builds it.
+// Unquotes are only evaluated at depth 0; nested quasiquotes go a level deeper.
+func quasiBuilder(template Expression, depth int) (Expression, error) {
+	call := func(proc Procedure, args ...Expression) Expression {
+		return &SexpPair{proc, toCode(args...), false, nil, nil}
+	}
+
+	// Rebuilds a nested (name x) form, with x at another depth
+	nested := func(name Symbol, arg Expression, argDepth int) (Expression, error) {
+		argBuilder, err := quasiBuilder(arg, argDepth)
+		if err != nil {
+			return nil, err
+		}
+		return call(quasiList, QuotedSymbol(name), argBuilder), nil
+	}
+
+	if arg, ok := isQuasiForm(template, "unquote"); ok {
+		if depth == 0 {
+			return arg, nil
 		}
-		frame.Args.val = proc
+		return nested("unquote", arg, depth-1)
+	}
+	if arg, ok := isQuasiForm(template, "quasiquote"); ok {
+		return nested("quasiquote", arg, depth+1)
+	}
 
-		return Get(args, 1), env, false, ""
-	case 3:
-		evalList := frame.StepInput
-		newArgs, wasList := evalList.(*SexpPair)
-		if !wasList {
-			return nil, nil, true, "List given to apply doesn't evaluate as a list."
+	pair, wasPair := template.(*SexpPair)
+	if !wasPair || pair == EmptyList || pair.literal {
+		return quoteDatum(template), nil
+	}
+
+	if arg, ok := isQuasiForm(pair.val, "unquote-splicing"); ok && depth == 0 {
+		restBuilder, err := quasiBuilder(pair.next, depth)
+		if err != nil {
+			return nil, err
 		}
-		args.next = newArgs
+		return call(quasiSplice, arg, restBuilder), nil
+	}
+
+	var headBuilder Expression
+	var err error
+	if arg, ok := isQuasiForm(pair.val, "unquote-splicing"); ok {
+		headBuilder, err = nested("unquote-splicing", arg, depth-1)
+	} else {
+		headBuilder, err = quasiBuilder(pair.val, depth)
+	}
+	if err != nil {
+		return nil, err
+	}
 
-		return args, env, true, ""
+	restBuilder, err := quasiBuilder(pair.next, depth)
+	if err != nil {
+		return nil, err
 	}
-	panic(errors.New(fmt.Sprintf("Invalid step %d in apply", frame.Step)))
+	return call(quasiCons, headBuilder, restBuilder), nil
 }
 
-func coreLet(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err string) {
-	args, env := frame.Args, frame.CurrentEnv
+func coreApply(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	env := frame.CurrentEnv
 	frame.Step++
 
-	if frame.Step == 1 {
-		if length, _ := args.Len(); length != 2 {
-			return nil, nil, true, "Let statements take two arguments: a list of bindings and an S-expression to evaluate."
-		}
+	next, done, err := frame.NextArg()
+	if err != nil {
+		return nil, nil, true, err
+	}
+	if !done {
+		return next, env, false, nil
+	}
 
-		letEnv := NewEnv()
-		letEnv.Outer = env
-		env = letEnv
-		frame.CurrentEnv = env
+	vals := frame.EvaluatedArgs
+	if len(vals) < 2 {
+		return nil, nil, true, errorf(SyntaxError, "apply needs a function and a list of arguments.")
+	}
 
-		// Check that our arguments are okay
-		bindings, bindsOk := Get(args, 0).(*SexpPair)
-		if !bindsOk {
-			return nil, nil, true, "First argument to a let statement must be a list of bindings."
-		} else if bindings.literal {
-			return nil, nil, true, "List of bindings cannot be literal."
-		}
+	proc, wasFunc := vals[0].(Procedure)
+	if !wasFunc {
+		return nil, nil, true, errorf(TypeError, "Function given to apply doesn't evaluate as a function.")
+	}
 
-		//Store expression to evaluate in the new environment temporarily
-		letEnv.Dict["__let_expression__"] = Get(args, 1)
+	// Any arguments between the function and the list are passed first, as in (apply + 1 2 '(3 4))
+	lst, wasList := vals[len(vals)-1].(*SexpPair)
+	listArgs, listErr := ToSlice(lst)
+	if !wasList || listErr != nil {
+		return nil, nil, true, errorf(TypeError, "List given to apply doesn't evaluate as a list.")
+	}
+	callArgs := append(vals[1:len(vals)-1:len(vals)-1], listArgs...)
 
-		//args now holds bindings only
-		frame.Args = bindings
-		args = frame.Args
-	} else {
-		// Bind previous variable
+	// Our frame becomes the call's, which takes care of popping it
+	result, nextEnv, err = frame.callWith(stack, proc, callArgs)
+	return result, nextEnv, false, err
+}
 
-		// Binding should be ok from last step
-		binding := args.val.(*SexpPair)
+// Kinds of let, which differ in where their bindings are evaluated and when they're bound
+type letKind int
 
-		// Symbol should be ok from last step
-		symbol := binding.val.(Symbol)
-		env.Dict[symbol] = frame.StepInput
+const (
+	// Evaluated in the outer scope, then bound all at once
+	letParallel letKind = iota
+	// Evaluated and bound one by one, each in the scope of the ones before
+	letSequential
+	// Evaluated in the new scope, where every binding is already visible, then bound all at once
+	letRecursive
+	// Like letRecursive, but each one is bound as soon as it's evaluated
+	letRecursiveSequential
+)
 
-		// All done, onto next arg
-		var argsOk bool
-		frame.Args, argsOk = args.next.(*SexpPair)
-		args = frame.Args
-		if !argsOk {
-			return nil, nil, true, "let: binding list not a list"
-		}
+func coreLet(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	return letForm(frame, stack, letParallel)
+}
+
+func coreLetStar(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	return letForm(frame, stack, letSequential)
+}
+
+func coreLetrec(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	return letForm(frame, stack, letRecursive)
+}
+
+func coreLetrecStar(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	return letForm(frame, stack, letRecursiveSequential)
+}
+
+// parsedLet is a let statement split into its parts.
+type parsedLet struct {
+	name  Symbol // "" unless it's a named let
+	names []Symbol
+	inits []Expression
+	body  Expression
+}
+
+// parseLet splits a let statement into its parts, checking that they're well formed.
+// Only a parallel let may be named, as in (let loop ((i 0)) ...).
+func parseLet(args *SexpPair, kind letKind) (name Symbol, names []Symbol, inits []Expression, body Expression, err error) {
+	if length, _ := args.Len(); length >= 3 && isIdentifier(args.val) && kind == letParallel {
+		name, _ = bindingName(args.val)
+		args, _ = args.next.(*SexpPair)
 	}
 
-	if args == EmptyList {
-		// All done binding, grab expression
-		expression := env.Dict["__let_expression__"]
-		delete(env.Dict, "__let_expression__")
+	if length, _ := args.Len(); length < 2 {
+		return "", nil, nil, nil, errorf(SyntaxError, "Let statements take two arguments: a list of bindings and an S-expression to evaluate.")
+	}
 
-		// And Go
-		return expression, env, true, ""
+	// Check that our arguments are okay
+	bindings, bindsOk := Get(args, 0).(*SexpPair)
+	if !bindsOk {
+		return "", nil, nil, nil, errorf(SyntaxError, "First argument to a let statement must be a list of bindings.")
+	} else if bindings != EmptyList && bindings.literal {
+		return "", nil, nil, nil, errorf(SyntaxError, "List of bindings cannot be literal.")
+	}
+	bindingSlice, listErr := ToSlice(bindings)
+	if listErr != nil {
+		return "", nil, nil, nil, errorf(SyntaxError, "let: binding list not a list")
 	}
 
-	bindNum := frame.Step
-	binding, bindOk := args.val.(*SexpPair)
-	// Check validity of binding (must be a symbol-value pair)
-	if !bindOk {
-		return nil, nil, true, fmt.Sprintf("Binding #%d is not an S-expression.", bindNum)
-	} else if bindLength, _ := binding.Len(); bindLength != 2 {
-		return nil, nil, true, fmt.Sprintf("Binding #%d does not have two elements.", bindNum)
-	} else if binding.literal {
-		return nil, nil, true, fmt.Sprintf("Binding #%d was literal; no binding may be literal.", bindNum)
+	for i, b := range bindingSlice {
+		bindNum := i + 1
+
+		binding, bindOk := b.(*SexpPair)
+		// Check validity of binding (must be a symbol-value pair)
+		if !bindOk {
+			return "", nil, nil, nil, errorf(SyntaxError, "Binding #%d is not an S-expression.", bindNum)
+		} else if bindLength, _ := binding.Len(); bindLength != 2 {
+			return "", nil, nil, nil, errorf(SyntaxError, "Binding #%d does not have two elements.", bindNum)
+		} else if binding.literal {
+			return "", nil, nil, nil, errorf(SyntaxError, "Binding #%d was literal; no binding may be literal.", bindNum)
+		}
+
+		// Check validity of symbol (must be a non-literal, non-empty string)
+		symbol, symOk := bindingName(binding.val)
+		if !symOk || symbol == "" {
+			return "", nil, nil, nil, errorf(SyntaxError, "Binding #%d has a non-string, empty string, or string literal symbol.", bindNum)
+		}
+
+		// Only let* may bind the same symbol twice, since each binding gets its own scope
+		if kind != letSequential {
+			for _, prev := range names {
+				if prev == symbol {
+					return "", nil, nil, nil, errorf(SyntaxError, "Binding #%d attempted to re-bind already bound symbol '%s'.", bindNum, symbol)
+				}
+			}
+		}
+
+		names = append(names, symbol)
+		inits = append(inits, Get(binding, 1))
 	}
 
-	// Check validity of symbol (must be a non-literal, non-empty string)
-	symbol, symOk := binding.val.(Symbol)
-	if !symOk || symbol == "" {
-		return nil, nil, true, fmt.Sprintf("Binding #%d has a non-string, empty string, or string literal symbol.", bindNum)
+	body, err = parseBody(args.next, "let")
+	return name, names, inits, body, err
+}
+
+// letForm evaluates one binding of a let statement per step, then hands back the body to be evaluated in the new scope.
+func letForm(frame *StackFrame, stack *Stack, kind letKind) (result Expression, nextEnv *Env, done bool, err error) {
+	frame.Step++
+
+	if frame.Step == 1 {
+		name, names, inits, body, err := parseLet(frame.Args, kind)
+		if err != nil {
+			return nil, nil, true, err
+		}
+		frame.let = &parsedLet{name, names, inits, body}
+
+		switch kind {
+		case letSequential:
+			letEnv := NewEnv()
+			letEnv.Outer = frame.CurrentEnv
+			frame.CurrentEnv = letEnv
+		case letRecursive, letRecursiveSequential:
+			// Everything is visible, but unassigned, while the bindings are evaluated
+			letEnv := NewEnv()
+			letEnv.Outer = frame.CurrentEnv
+			for _, symbol := range names {
+				letEnv.Dict[symbol] = PTBlank
+			}
+			frame.CurrentEnv = letEnv
+		}
 	}
-	if symbol == "__let_expression__" {
-		return nil, nil, true, "let: unable to bind internal symbol \"__let_expression__\"."
+	name, names, inits, body := frame.let.name, frame.let.names, frame.let.inits, frame.let.body
+
+	if frame.Step > 1 {
+		// Deal with the previous binding's value
+		bound := frame.Step - 2
+		switch kind {
+		case letParallel, letRecursive:
+			frame.EvaluatedArgs = append(frame.EvaluatedArgs, frame.StepInput)
+		case letSequential:
+			frame.CurrentEnv = MakeEnv(names[bound:bound+1], []Expression{frame.StepInput}, frame.CurrentEnv)
+		case letRecursiveSequential:
+			frame.CurrentEnv.Dict[names[bound]] = frame.StepInput
+		}
 	}
-	if _, ok := env.Dict[symbol]; ok {
-		return nil, nil, true, fmt.Sprintf("Binding #%d attempted to re-bind already bound symbol '%s'.", bindNum, symbol)
+
+	if next := frame.Step - 1; next < len(inits) {
+		// Evaluate the binding value before it's bound
+		return inits[next], frame.CurrentEnv, false, nil
 	}
 
-	// Evaluate the binding value before it's bound
-	// NOTE: Is it possible that, during sequential evaluation, the environment could be changed and cause the same text to evaluate as two different things?
-	next, _ := binding.next.(*SexpPair)
-	return next.val, env, false, ""
+	// All done binding, grab the body
+	env := frame.CurrentEnv
+	switch kind {
+	case letParallel:
+		if name != "" {
+			// A named let calls a procedure, bound to its name within its own body
+			loopEnv := NewEnv()
+			loopEnv.Outer = env
+			loop := &Proc{Name: string(name), Vars: names, Exp: body, EvalEnv: loopEnv}
+			loopEnv.Dict[name] = loop
+			result, nextEnv, err = frame.callWith(stack, loop, frame.EvaluatedArgs)
+			return result, nextEnv, false, err
+		}
+		env = MakeEnv(names, frame.EvaluatedArgs, env)
+	case letRecursive:
+		for i, symbol := range names {
+			env.Dict[symbol] = frame.EvaluatedArgs[i]
+		}
+	}
+
+	return body, env, true, nil
 }
 
-func coreCond(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err string) {
+func coreCond(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
 	args, env := frame.Args, frame.CurrentEnv
 	frame.Step++
 
 	if args == EmptyList {
-		return nil, nil, true, "Must give at least one clause to cond."
+		return nil, nil, true, errorf(SyntaxError, "Must give at least one clause to cond.")
 	}
 
 	if frame.Step > 1 {
 		// Get test result from last time
 		testResult, resultOk := frame.StepInput.(PTBool)
 		if !resultOk {
-			return nil, nil, true, fmt.Sprintf("Clause #%d's test expression did not evaluate to a bool.", frame.Step-1)
+			return nil, nil, true, errorf(TypeError, "Clause #%d's test expression did not evaluate to a bool.", frame.Step-1)
 		}
 		// If the test passed, evaluate and return the result.
 		if testResult {
 			//clause valid from last time
 			clause := args.val.(*SexpPair)
-			//FIXME: what if this isn't true?
-			next := clause.next.(*SexpPair)
-			return next.val, env, true, ""
+			body, err := parseBody(clause.next, "cond")
+			if err != nil {
+				return nil, nil, true, err
+			}
+			// The body gets its own scope, like a lambda's, so defines within it stay there
+			bodyEnv := NewEnv()
+			bodyEnv.Outer = env
+			return body, bodyEnv, true, nil
 		}
 
 		// Done with last step's condition, on to the next
@@ -277,10 +665,10 @@ func coreCond(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, don
 		args, argsOk = args.next.(*SexpPair)
 		frame.Args = args
 		if !argsOk {
-			return nil, nil, true, "cond: argument list not a list"
+			return nil, nil, true, errorf(SyntaxError, "cond: argument list not a list")
 		}
 		if args == EmptyList {
-			return nil, nil, true, "At least one test given to cond must pass."
+			return nil, nil, true, errorf(ValueError, "At least one test given to cond must pass.")
 		}
 	}
 
@@ -289,43 +677,425 @@ func coreCond(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, don
 	// Check the validity of the clause.
 	clause, clauseOk := args.val.(*SexpPair)
 	if !clauseOk {
-		return nil, nil, true, fmt.Sprintf("Clause #%d was not a list.", clauseNum)
-	} else if length, _ := clause.Len(); length != 2 {
-		return nil, nil, true, fmt.Sprintf("Clause #%d was a list with more than two elements.", clauseNum)
+		return nil, nil, true, errorf(SyntaxError, "Clause #%d was not a list.", clauseNum)
+	} else if length, _ := clause.Len(); length < 2 {
+		return nil, nil, true, errorf(SyntaxError, "Clause #%d needs a test and at least one expression.", clauseNum)
 	} else if clause.literal {
-		return nil, nil, true, fmt.Sprintf("Clause #%d was a literal list. Clauses may not be literal lists.", clauseNum)
+		return nil, nil, true, errorf(SyntaxError, "Clause #%d was a literal list. Clauses may not be literal lists.", clauseNum)
 	}
 
 	// Evaluate the clause's test
-	return clause.val, env, false, ""
+	return clause.val, env, false, nil
 }
 
-func coreBegin(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err string) {
-	if frame.Step == 0 {
-		frame.Step++
-		ourEnv := NewEnv()
-		ourEnv.Outer = frame.CurrentEnv
-		frame.CurrentEnv = ourEnv
+// coreBegin evaluates expressions in sequence.
+// It doesn't open a new scope, so defines within it are made in the scope around it.
+func coreBegin(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	if frame.Args == EmptyList {
+		return PTBlank, nil, true, nil
 	}
 
 	args, env := frame.Args, frame.CurrentEnv
 
-	if args == EmptyList {
-		//all done, return last result
-		return frame.StepInput, env, true, ""
+	next, argsOk := args.next.(*SexpPair)
+	if !argsOk {
+		return nil, nil, true, errorf(SyntaxError, "begin: expression list not a list")
+	}
+	if next == EmptyList {
+		// The last expression is in tail position, so we're done with our frame
+		return args.val, env, true, nil
 	}
 
-	var argsOk bool
-	frame.Args, argsOk = args.next.(*SexpPair)
-	if !argsOk {
-		return nil, nil, true, "begin: expression list not a list"
+	frame.Args = next
+	return args.val, env, false, nil
+}
+
+// syntaxOnly is the core form for a keyword the expander deals with entirely, which is an error anywhere it's left to be evaluated.
+func syntaxOnly(name string) CoreFunc {
+	return func(_ *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+		return nil, nil, true, errorf(SyntaxError, "Bad syntax: %s can only be used where macros are expanded.", name)
+	}
+}
+
+// coreWithExceptionHandler calls a thunk with a handler installed for anything raised while it runs.
+func coreWithExceptionHandler(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	env := frame.CurrentEnv
+	frame.Step++
+
+	next, done, err := frame.NextArg()
+	if err != nil {
+		return nil, nil, true, err
+	}
+	if !done {
+		return next, env, false, nil
+	}
+
+	if len(frame.EvaluatedArgs) != 2 {
+		return nil, nil, true, errorf(ArityError, "with-exception-handler takes a handler and a thunk.")
+	}
+	handler, handlerOk := frame.EvaluatedArgs[0].(Procedure)
+	thunk, thunkOk := frame.EvaluatedArgs[1].(Procedure)
+	if !handlerOk || !thunkOk {
+		return nil, nil, true, errorf(TypeError, "Handler and thunk given to with-exception-handler must be procedures.")
+	}
+
+	// Our frame stays on the stack to mark the handler as in effect until the thunk returns
+	frame.Running = &ExceptionHandler{handler}
+	return toCode(thunk), env, false, nil
+}
+
+// coreGuard evaluates a body, and if anything is raised while it runs, evaluates clauses like a cond with the raised object bound.
+func coreGuard(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	args := frame.Args
+
+	if length, _ := args.Len(); length < 2 {
+		return nil, nil, true, errorf(SyntaxError, "guard needs a variable and clauses, and a body.")
+	}
+	spec, specOk := args.val.(*SexpPair)
+	if !specOk || spec == EmptyList || spec.literal {
+		return nil, nil, true, errorf(SyntaxError, "First argument to guard must be a list of a variable and clauses.")
+	}
+	name, nameOk := bindingName(spec.val)
+	if !nameOk {
+		return nil, nil, true, errorf(SyntaxError, "Variable given to guard wasn't a symbol.")
+	}
+	clauseList, listOk := spec.next.(*SexpPair)
+	if !listOk {
+		return nil, nil, true, errorf(SyntaxError, "guard: clause list not a list")
+	}
+	clauses, listErr := ToSlice(clauseList)
+	if listErr != nil {
+		return nil, nil, true, errorf(SyntaxError, "guard: clause list not a list")
+	}
+	body, err := parseBody(args.next, "guard")
+	if err != nil {
+		return nil, nil, true, err
+	}
+
+	// Our frame stays on the stack to mark the guard as in effect until the body returns
+	frame.Running = &Guard{name, clauses}
+	return body, frame.CurrentEnv, false, nil
+}
+
+// coreDynamicWind calls a thunk, calling a before thunk whenever its extent is entered and an after thunk whenever it's left.
+// Besides on the way in and out, that's when a continuation or error jumps across the extent.
+func coreDynamicWind(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	env := frame.CurrentEnv
+	frame.Step++
+
+	switch frame.Step {
+	case 1:
+		if length, _ := frame.Args.Len(); length != 3 {
+			return nil, nil, true, errorf(ArityError, "dynamic-wind takes a before thunk, a thunk and an after thunk.")
+		}
+		fallthrough
+	case 2, 3, 4:
+		next, done, err := frame.NextArg()
+		if err != nil {
+			return nil, nil, true, err
+		}
+		if !done {
+			return next, env, false, nil
+		}
+
+		for _, thunk := range frame.EvaluatedArgs {
+			if _, ok := thunk.(Procedure); !ok {
+				return nil, nil, true, errorf(TypeError, "Thunks given to dynamic-wind must be procedures.")
+			}
+		}
+		return toCode(frame.EvaluatedArgs[0]), env, false, nil
+	case 5:
+		// The before thunk has returned, so we're in the extent
+		frame.Wind = &Wind{frame.EvaluatedArgs[0].(Procedure), frame.EvaluatedArgs[2].(Procedure)}
+		return toCode(frame.EvaluatedArgs[1]), env, false, nil
+	case 6:
+		// Leave the extent before the after thunk runs, keeping the thunk's result for last
+		frame.Wind = nil
+		frame.EvaluatedArgs = append(frame.EvaluatedArgs, frame.StepInput)
+		return toCode(frame.EvaluatedArgs[2]), env, false, nil
+	case 7:
+		return frame.EvaluatedArgs[3], env, true, nil
+	}
+	panic(errors.New(fmt.Sprintf("Invalid step %d in dynamic-wind", frame.Step)))
+}
+
+// coreCallWithValues calls a producer thunk, then calls a consumer with whatever values it returned.
+func coreCallWithValues(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	env := frame.CurrentEnv
+	frame.Step++
+
+	switch frame.Step {
+	case 1:
+		if length, _ := frame.Args.Len(); length != 2 {
+			return nil, nil, true, errorf(ArityError, "call-with-values takes a producer and a consumer.")
+		}
+		fallthrough
+	case 2, 3:
+		next, done, err := frame.NextArg()
+		if err != nil {
+			return nil, nil, true, err
+		}
+		if !done {
+			return next, env, false, nil
+		}
+
+		producer, producerOk := frame.EvaluatedArgs[0].(Procedure)
+		_, consumerOk := frame.EvaluatedArgs[1].(Procedure)
+		if !producerOk || !consumerOk {
+			return nil, nil, true, errorf(TypeError, "Producer and consumer given to call-with-values must be procedures.")
+		}
+		return toCode(producer), env, false, nil
+	case 4:
+		// The values are already evaluated, so our frame becomes the consumer's call
+		consumer := frame.EvaluatedArgs[1].(Procedure)
+		result, nextEnv, err = frame.callWith(stack, consumer, Values(frame.StepInput))
+		return result, nextEnv, false, err
+	}
+	panic(errors.New(fmt.Sprintf("Invalid step %d in call-with-values", frame.Step)))
+}
+
+// coreLetValues is like let, but binds each binding's formals, given like a lambda's parameters, to the values its expression returns.
+func coreLetValues(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	args, env := frame.Args, frame.CurrentEnv
+	frame.Step++
+
+	if length, _ := args.Len(); length < 2 {
+		return nil, nil, true, errorf(SyntaxError, "let-values needs a list of bindings and a body.")
+	}
+	bindingList, bindsOk := args.val.(*SexpPair)
+	if !bindsOk || (bindingList != EmptyList && bindingList.literal) {
+		return nil, nil, true, errorf(SyntaxError, "First argument to let-values must be a list of bindings.")
 	}
-	return args.val, env, false, ""
+	bindings, listErr := ToSlice(bindingList)
+	if listErr != nil {
+		return nil, nil, true, errorf(SyntaxError, "let-values: binding list not a list")
+	}
+
+	if frame.Step > 1 {
+		frame.EvaluatedArgs = append(frame.EvaluatedArgs, frame.StepInput)
+	}
+
+	// Evaluate the expressions one per step, all in the outer scope
+	if i := frame.Step - 1; i < len(bindings) {
+		binding, bindOk := bindings[i].(*SexpPair)
+		if length, _ := binding.Len(); !bindOk || length != 2 || binding.literal {
+			return nil, nil, true, errorf(SyntaxError, "Binding #%d of let-values must be formals and an expression.", i+1)
+		}
+		return Get(binding, 1), env, false, nil
+	}
+
+	scope := MakeEnv(nil, nil, env)
+	for i, binding := range bindings {
+		formals, err := parseParams(Get(binding.(*SexpPair), 0))
+		if err != nil {
+			return nil, nil, true, err
+		}
+
+		vals := Values(frame.EvaluatedArgs[i])
+		if !formals.Accepts(len(vals)) {
+			return nil, nil, true, errorf(ArityError, "Binding #%d of let-values can't take %d values.", i+1, len(vals))
+		}
+		// Optional parameters left out are #f; there's nowhere to evaluate defaults
+		for len(vals) < len(formals.Vars)+len(formals.Optional) {
+			vals = append(vals, PTBool(false))
+		}
+		for name, val := range formals.bind(vals).Dict {
+			scope.Dict[name] = val
+		}
+	}
+
+	body, err := parseBody(args.next, "let-values")
+	if err != nil {
+		return nil, nil, true, err
+	}
+	return body, scope, true, nil
+}
+
+// coreDefineRecordType defines a record type, along with a constructor, a predicate, and an accessor and optionally a modifier for each field.
+// It looks like (define-record-type point (make-point x y) point? (x point-x set-point-x!) (y point-y)).
+func coreDefineRecordType(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	args, env := frame.Args, frame.CurrentEnv
+
+	specs, listErr := ToSlice(args)
+	if listErr != nil || len(specs) < 3 {
+		return nil, nil, true, errorf(SyntaxError, "define-record-type needs a type name, a constructor, a predicate and fields.")
+	}
+	typeName, typeOk := bindingName(specs[0])
+	predName, predOk := bindingName(specs[2])
+	if !typeOk || !predOk {
+		return nil, nil, true, errorf(SyntaxError, "Type and predicate names given to define-record-type must be symbols.")
+	}
+	recordType := &RecordType{Name: string(baseSymbol(specs[0]))}
+
+	// Each field spec is (field accessor) or (field accessor modifier)
+	defs := make(map[Symbol]Expression)
+	for i, spec := range specs[3:] {
+		fieldSpec, specOk := spec.(*SexpPair)
+		fieldParts, partsErr := ToSlice(fieldSpec)
+		if !specOk || partsErr != nil || len(fieldParts) < 2 || len(fieldParts) > 3 {
+			return nil, nil, true, errorf(SyntaxError, "Field #%d of define-record-type must be a field name, an accessor and an optional modifier.", i+1)
+		}
+		for _, part := range fieldParts {
+			if !isIdentifier(part) {
+				return nil, nil, true, errorf(SyntaxError, "Field #%d of define-record-type contains a non-symbol.", i+1)
+			}
+		}
+
+		field := baseSymbol(fieldParts[0])
+		if recordType.fieldIndex(field) != -1 {
+			return nil, nil, true, errorf(SyntaxError, "Field '%s' of define-record-type is given twice.", field)
+		}
+		recordType.Fields = append(recordType.Fields, field)
+
+		accessor, _ := bindingName(fieldParts[1])
+		accessorName := string(baseSymbol(fieldParts[1]))
+		defs[accessor] = &GoProc{accessorName, recordType.accessor(accessorName, i)}
+		if len(fieldParts) == 3 {
+			modifier, _ := bindingName(fieldParts[2])
+			modifierName := string(baseSymbol(fieldParts[2]))
+			defs[modifier] = &GoProc{modifierName, recordType.modifier(modifierName, i)}
+		}
+	}
+
+	// The constructor spec is (name field...), naming the fields it takes values for
+	ctorSpec, ctorOk := specs[1].(*SexpPair)
+	ctorParts, ctorErr := ToSlice(ctorSpec)
+	if !ctorOk || ctorErr != nil || len(ctorParts) < 1 || !isIdentifier(ctorParts[0]) {
+		return nil, nil, true, errorf(SyntaxError, "Constructor given to define-record-type must be a name and fields.")
+	}
+	var ctorFields []int
+	for _, part := range ctorParts[1:] {
+		field := recordType.fieldIndex(baseSymbol(part))
+		if !isIdentifier(part) || field == -1 {
+			return nil, nil, true, errorf(SyntaxError, "Constructor given to define-record-type takes '%s', which isn't a field.", SexpToString(part))
+		}
+		ctorFields = append(ctorFields, field)
+	}
+	ctor, _ := bindingName(ctorParts[0])
+	ctorName := string(baseSymbol(ctorParts[0]))
+	defs[ctor] = &GoProc{ctorName, recordType.constructor(ctorName, ctorFields)}
+
+	defs[typeName] = recordType
+	defs[predName] = &GoProc{string(baseSymbol(specs[2])), recordType.predicate()}
+	for name, def := range defs {
+		env.Dict[name] = def
+	}
+	return PTBlank, nil, true, nil
+}
+
+// coreDelay makes a promise to evaluate an expression when it's forced.
+func coreDelay(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	return delayForm(frame, "delay", false)
+}
+
+// coreDelayForce makes a promise to evaluate an expression yielding a promise, and force that, when it's forced.
+func coreDelayForce(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	return delayForm(frame, "delay-force", true)
+}
+
+func delayForm(frame *StackFrame, form string, lazy bool) (result Expression, nextEnv *Env, done bool, err error) {
+	if length, _ := frame.Args.Len(); length != 1 {
+		return nil, nil, true, errorf(SyntaxError, "%s takes exactly one expression.", form)
+	}
+	return &Promise{&promiseState{Exp: frame.Args.val, Env: frame.CurrentEnv, Lazy: lazy}}, frame.CurrentEnv, true, nil
 }
 
-func haveANiceDay(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err string) {
-	fmt.Println("\nhave a nice day ;)")
-	os.Exit(0)
+// coreForce evaluates a promise's expression, if it hasn't been already, and returns its value.
+// Our frame stays on the stack while the expression is evaluated, to remember the value once it comes back.
+func coreForce(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	args, env := frame.Args, frame.CurrentEnv
+	frame.Step++
+
+	switch frame.Step {
+	case 1:
+		if length, _ := args.Len(); length != 1 {
+			return nil, nil, true, errorf(ArityError, "force takes exactly one argument.")
+		}
+		return args.val, env, false, nil
+	case 2:
+		promise, ok := frame.StepInput.(*Promise)
+		if !ok {
+			// Forcing anything else just gives it back
+			return frame.StepInput, env, true, nil
+		}
+		frame.EvaluatedArgs = []Expression{promise}
+	}
+
+	promise := frame.EvaluatedArgs[0].(*Promise)
+	state := promise.state
+	if frame.Step > 2 && !state.Done {
+		if !state.Lazy {
+			state.Done, state.Value = true, frame.StepInput
+		} else if next, ok := frame.StepInput.(*Promise); !ok {
+			return nil, nil, true, errorf(TypeError, "Expression given to delay-force did not evaluate to a promise.")
+		} else {
+			// Take over the promise we were given, and have it share our state from now on
+			*state = *next.state
+			next.state = state
+		}
+	}
+
+	if state.Done {
+		return state.Value, env, true, nil
+	}
+	return state.Exp, state.Env, false, nil
+}
+
+// coreTrace wraps the procedures bound to the identifiers it's given, so their calls are written to the trace port.
+func coreTrace(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	return retrace(frame, "trace")
+}
+
+// coreUntrace undoes coreTrace.
+func coreUntrace(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	return retrace(frame, "untrace")
+}
+
+// retrace rebinds each identifier given to trace or untrace to its procedure, wrapped or unwrapped.
+// Procedures already in the state asked for are left be.
+func retrace(frame *StackFrame, form string) (result Expression, nextEnv *Env, done bool, err error) {
+	env := frame.CurrentEnv
+	idents, listErr := ToSlice(frame.Args)
+	if listErr != nil {
+		return nil, nil, true, errorf(SyntaxError, "%s takes the names of procedures.", form)
+	}
+
+	for _, ident := range idents {
+		if !isIdentifier(ident) {
+			return nil, nil, true, errorf(SyntaxError, "%s takes the names of procedures, and %s isn't a name.", form, SexpToString(ident))
+		}
+		val, _, lookupErr := ident.Eval(nil, env)
+		if lookupErr != nil {
+			return nil, nil, true, lookupErr
+		}
+
+		switch proc := val.(type) {
+		case *Proc, *GoProc:
+			if form == "untrace" {
+				continue
+			}
+			ports := env.outputPorts()
+			if ports == nil {
+				return nil, nil, true, errorf(ValueError, "There's no trace port to trace %s to.", baseSymbol(ident))
+			}
+			val = &Traced{proc.(Procedure), string(baseSymbol(ident)), ports}
+		case *Traced:
+			if form == "trace" {
+				continue
+			}
+			val = proc.Proc
+		default:
+			return nil, nil, true, errorf(TypeError, "Only procedures can be traced, and %s is %s.", baseSymbol(ident), SexpToString(val))
+		}
+
+		if setErr := setIdentifier(env, ident, val); setErr != nil {
+			return nil, nil, true, errorf(UnboundError, "%s", setErr)
+		}
+	}
+	return PTBlank, env, true, nil
+}
 
-	return nil, nil, true, "Unreachable code."
+// haveANiceDay stops evaluation with ErrExit, leaving it to whoever's evaluating to decide what exiting means.
+func haveANiceDay(frame *StackFrame, _ *Stack) (result Expression, nextEnv *Env, done bool, err error) {
+	return nil, nil, true, ErrExit
 }
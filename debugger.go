@@ -0,0 +1,276 @@
+package golftalk
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+type debugMode int
+
+const (
+	debugContinue debugMode = iota // stop only at breakpoints
+	debugStep                      // stop before every evaluation
+	debugNext                      // stop before the next evaluation no deeper in the Stack
+	debugFinish                    // stop once the frame stopped in has returned
+)
+
+// Debugger steps through evaluations, stopping before a call to any procedure with a breakpoint on it and taking commands about what to do next.
+type Debugger struct {
+	Breakpoints map[string]bool
+
+	in   *bufio.Reader
+	out  io.Writer
+	mode debugMode
+	// depth is the height of the Stack when next or finish was asked for
+	depth int
+	// last is the command to repeat on an empty line
+	last string
+}
+
+var debuggerHelp = `Commands:
+	step (s)           evaluate until the next expression
+	next (n)           evaluate until the next expression in this frame or an outer one
+	finish (f)         evaluate until the innermost frame returns
+	continue (c)       evaluate until the next breakpoint
+	frames (bt)        list the frames on the stack, innermost first
+	frame N            show what's running in frame N, its arguments and its bindings
+	eval EXPR (p)      evaluate EXPR in the environment of the frame last shown
+	break NAME (b)     stop before calls to the procedure NAME
+	delete NAME (d)    remove the breakpoint on NAME
+	breakpoints        list the breakpoints
+	quit (q)           abandon the evaluation`
+
+func NewDebugger(in *bufio.Reader, out io.Writer) *Debugger {
+	return &Debugger{Breakpoints: make(map[string]bool), in: in, out: out}
+}
+
+// Eval evaluates expr in env, stopping at any breakpoints along the way.
+func (d *Debugger) Eval(expr Expression, env *Env) (Expression, error) {
+	return evalWatched(expr, env, d)
+}
+
+// Step evaluates expr in env, stopping before its first step.
+func (d *Debugger) Step(expr Expression, env *Env) (Expression, error) {
+	d.Pause()
+	return evalWatched(expr, env, d)
+}
+
+// Pause makes the debugger stop before the next step it's shown.
+func (d *Debugger) Pause() {
+	d.mode = debugStep
+}
+
+func (d *Debugger) beforeStep(expr Expression, env *Env, stack Stack) error {
+	if expr.IsLiteral() {
+		// Values being handed back to frames aren't worth stopping for
+		return nil
+	}
+
+	var stop bool
+	switch d.mode {
+	case debugStep:
+		stop = true
+	case debugNext:
+		stop = len(stack) <= d.depth
+	case debugFinish:
+		stop = len(stack) < d.depth
+	}
+	if name, ok := d.breakpoint(expr, env); ok {
+		fmt.Fprintf(d.out, "Breakpoint: %s\n", name)
+		stop = true
+	}
+	if !stop {
+		return nil
+	}
+	return d.prompt(expr, env, stack)
+}
+
+func (_ *Debugger) beforeExpand(form Expression, depth int) error {
+	return nil
+}
+
+// done leaves the debugger to stop only at breakpoints in the next evaluation it watches.
+func (d *Debugger) done() {
+	d.mode = debugContinue
+}
+
+// breakpoint reports whether expr is a call to a procedure with a breakpoint on it, either by the name it's called as or by the procedure's own name.
+func (d *Debugger) breakpoint(expr Expression, env *Env) (name string, ok bool) {
+	call, isCall := expr.(*SexpPair)
+	if len(d.Breakpoints) == 0 || !isCall || call == EmptyList || !isIdentifier(call.val) {
+		return "", false
+	}
+
+	name = string(baseSymbol(call.val))
+	if d.Breakpoints[name] {
+		return name, true
+	}
+	if sym, isSym := call.val.(Symbol); isSym {
+		if val, err := env.Get(sym); err == nil {
+			switch proc := val.(type) {
+			case *Proc:
+				name = proc.Name
+			case *GoProc:
+				name = proc.Name
+			}
+			return name, name != "" && d.Breakpoints[name]
+		}
+	}
+	return "", false
+}
+
+// prompt takes commands until one of them resumes evaluation.
+// Frame 0 is the expression about to be evaluated, and the frames of the Stack follow it, innermost first.
+func (d *Debugger) prompt(expr Expression, env *Env, stack Stack) error {
+	frames := debugFrames(expr, env, stack)
+	selected := frames[0]
+	fmt.Fprintf(d.out, "-> %s\n", selected.describe())
+
+	for {
+		fmt.Fprint(d.out, "debug> ")
+		line, err := d.in.ReadString('\n')
+		if err != nil && line == "" {
+			fmt.Fprintln(d.out)
+			return errorf(UserError, "Evaluation abandoned in the debugger.")
+		}
+
+		line = strings.TrimSpace(line)
+		if line == "" {
+			line = d.last
+		}
+		d.last = line
+		command, rest := line, ""
+		if i := strings.IndexAny(line, " \t"); i != -1 {
+			command, rest = line[:i], strings.TrimSpace(line[i:])
+		}
+
+		switch command {
+		case "":
+		case "step", "s":
+			d.mode = debugStep
+			return nil
+		case "next", "n":
+			d.mode, d.depth = debugNext, len(stack)
+			return nil
+		case "finish", "f":
+			d.mode, d.depth = debugFinish, len(stack)
+			return nil
+		case "continue", "c":
+			d.mode = debugContinue
+			return nil
+		case "quit", "q":
+			return errorf(UserError, "Evaluation abandoned in the debugger.")
+		case "frames", "bt":
+			for i, frame := range frames {
+				fmt.Fprintf(d.out, "#%d %s\n", i, frame.describe())
+			}
+		case "frame":
+			i, convErr := strconv.Atoi(rest)
+			if convErr != nil || i < 0 || i >= len(frames) {
+				fmt.Fprintf(d.out, "No frame %q; there are frames 0 to %d.\n", rest, len(frames)-1)
+				continue
+			}
+			selected = frames[i]
+			selected.show(d.out)
+		case "eval", "p":
+			d.eval(rest, selected.env)
+		case "break", "b":
+			d.Breakpoints[rest] = true
+		case "delete", "d":
+			delete(d.Breakpoints, rest)
+		case "breakpoints":
+			names := make([]string, 0, len(d.Breakpoints))
+			for name := range d.Breakpoints {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			fmt.Fprintln(d.out, strings.Join(names, " "))
+		case "help", "h", "?":
+			fmt.Fprintln(d.out, debuggerHelp)
+		default:
+			fmt.Fprintf(d.out, "Unknown command %q; try help.\n", command)
+		}
+	}
+}
+
+// eval evaluates the source in env, without stopping anywhere.
+func (d *Debugger) eval(source string, env *Env) {
+	sexps, err := ParseLine(source)
+	if err != nil {
+		fmt.Fprintln(d.out, err)
+		return
+	}
+	for _, sexp := range sexps {
+		result, err := Eval(sexp, env)
+		var evalErr *EvalError
+		if errors.As(err, &evalErr) {
+			fmt.Fprintln(d.out, evalErr.Traceback())
+			return
+		}
+		fmt.Fprintln(d.out, SexpToString(result))
+	}
+}
+
+// debugFrame is something the debugger can show: either the expression about to be evaluated, or a frame of the Stack.
+type debugFrame struct {
+	expr  Expression
+	frame *StackFrame
+	env   *Env
+	pos   *SourcePos
+}
+
+func debugFrames(expr Expression, env *Env, stack Stack) []debugFrame {
+	frames := []debugFrame{{expr: expr, env: env, pos: sourcePos(expr, stack)}}
+	for i := len(stack) - 1; i >= 0; i-- {
+		frames = append(frames, debugFrame{frame: &stack[i], env: stack[i].CurrentEnv, pos: stack[i].Pos()})
+	}
+	return frames
+}
+
+func (f debugFrame) describe() string {
+	desc := SexpToString(f.expr)
+	if f.frame != nil {
+		desc = f.frame.Name()
+	}
+	if f.pos != nil {
+		desc = fmt.Sprintf("%s at %s", desc, f.pos)
+	}
+	return desc
+}
+
+func (f debugFrame) show(out io.Writer) {
+	fmt.Fprintln(out, f.describe())
+	if frame := f.frame; frame != nil {
+		if frame.Call != nil {
+			fmt.Fprintf(out, "  call: %s\n", SexpToString(frame.Call))
+		}
+		if frame.Running != nil {
+			fmt.Fprintf(out, "  running: %s\n", SexpToString(frame.Running))
+		}
+		if len(frame.EvaluatedArgs) > 0 {
+			fmt.Fprintf(out, "  evaluated: %s\n", SexpToString(toCode(frame.EvaluatedArgs...)))
+		}
+		if frame.Args != EmptyList {
+			fmt.Fprintf(out, "  pending: %s\n", SexpToString(frame.Args))
+		}
+	}
+
+	if f.env == nil || f.env.Outer == nil {
+		fmt.Fprintln(out, "  bindings: (global)")
+		return
+	}
+	names := make([]string, 0, len(f.env.Dict))
+	for name := range f.env.Dict {
+		names = append(names, string(name))
+	}
+	sort.Strings(names)
+	fmt.Fprintln(out, "  bindings:")
+	for _, name := range names {
+		fmt.Fprintf(out, "    %s = %s\n", name, SexpToString(f.env.Dict[Symbol(name)]))
+	}
+}
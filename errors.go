@@ -0,0 +1,178 @@
+package golftalk
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrorKind says what sort of thing went wrong during evaluation.
+type ErrorKind int
+
+const (
+	// TypeError means a value of the wrong type was given to a procedure or special form.
+	TypeError ErrorKind = iota
+	// ArityError means a procedure was called with the wrong number of arguments.
+	ArityError
+	// UnboundError means a symbol wasn't bound anywhere in the scope chain.
+	UnboundError
+	// SyntaxError means a special form or macro use was malformed.
+	SyntaxError
+	// ValueError means a value had the right type but couldn't be used, like a zero divisor or an index out of range.
+	ValueError
+	// UserError means the program itself signalled the error.
+	UserError
+	// LimitError means evaluation was stopped before it finished, by EvalContext's context or limits.
+	LimitError
+	// InternalError means the interpreter itself went wrong.
+	InternalError
+)
+
+var errorKindNames = map[ErrorKind]string{
+	TypeError:     "type error",
+	ArityError:    "arity error",
+	UnboundError:  "unbound variable",
+	SyntaxError:   "syntax error",
+	ValueError:    "value error",
+	UserError:     "error",
+	LimitError:    "evaluation stopped",
+	InternalError: "internal error",
+}
+
+func (k ErrorKind) String() string {
+	if name, ok := errorKindNames[k]; ok {
+		return name
+	}
+	return fmt.Sprintf("ErrorKind(%d)", int(k))
+}
+
+// ErrExit is returned by Eval when the program calls exit.
+var ErrExit = errors.New("exit called")
+
+// EvalError is the error returned when evaluation fails.
+// Eval fills in the offending expression and the procedures on the Stack at the time, if whatever raised the error didn't.
+type EvalError struct {
+	Kind    ErrorKind
+	Message string
+	Expr    Expression   // the expression being evaluated when the error occurred
+	Pos     *SourcePos   // where the offending expression was read from, as near as is known
+	Trace   []TraceEntry // the procedures on the Stack, innermost first
+
+	// Raised is the object handed to exception handlers, if the program raised one.
+	Raised Expression
+	// Continuable is set for errors signalled by raise-continuable, whose handlers may return to the raise.
+	Continuable bool
+	// Err is the error underlying this one, if any, for errors.Is and errors.As.
+	Err error
+}
+
+func (e *EvalError) Error() string {
+	return e.Message
+}
+
+func (e *EvalError) Unwrap() error {
+	return e.Err
+}
+
+// Traceback describes the error along with where it happened, innermost call last.
+func (e *EvalError) Traceback() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s: %s", e.Kind, e.Message)
+	if e.Expr != nil {
+		fmt.Fprintf(&b, "\n\tin expression: %s", SexpToString(e.Expr))
+	}
+	if e.Pos != nil {
+		fmt.Fprintf(&b, "\n\tat %s", e.Pos)
+	}
+	if len(e.Trace) > 0 {
+		b.WriteString("\nTraceback (innermost last):")
+		for i := len(e.Trace) - 1; i >= 0; i-- {
+			fmt.Fprintf(&b, "\n\t%s", e.Trace[i])
+		}
+	}
+	return b.String()
+}
+
+// TraceEntry is a procedure on the Stack when an error occurred, and where it was called from if that's known.
+type TraceEntry struct {
+	Name string
+	Pos  *SourcePos
+}
+
+func (t TraceEntry) String() string {
+	if t.Pos == nil {
+		return t.Name
+	}
+	return fmt.Sprintf("%s at %s", t.Name, t.Pos)
+}
+
+// errorf makes an EvalError of the given kind, formatting its message like fmt.Sprintf.
+func errorf(kind ErrorKind, format string, args ...interface{}) *EvalError {
+	return &EvalError{Kind: kind, Message: fmt.Sprintf(format, args...)}
+}
+
+// annotate fills in the context of an error raised while evaluating expr with the given stack.
+// Errors that aren't already EvalErrors are wrapped up as though they were raised by the program.
+func annotate(err error, expr Expression, stack Stack) *EvalError {
+	evalErr, ok := err.(*EvalError)
+	if !ok {
+		evalErr = &EvalError{Kind: UserError, Message: err.Error()}
+	}
+	if evalErr.Expr == nil {
+		evalErr.Expr = expr
+	}
+	if evalErr.Pos == nil {
+		evalErr.Pos = sourcePos(evalErr.Expr, stack)
+	}
+	if evalErr.Trace == nil {
+		evalErr.Trace = stack.Trace()
+	}
+	return evalErr
+}
+
+// sourcePos finds where expr was read from.
+// A parsed list knows where it was opened; anything else was read where the pair holding it was, if it's the element the innermost call was evaluating.
+// Failing that, the position of the innermost call that has one is the best there is.
+func sourcePos(expr Expression, stack Stack) *SourcePos {
+	if list, ok := expr.(*SexpPair); ok && list != EmptyList && list.open != nil {
+		return list.open
+	}
+	if len(stack) == 0 {
+		return nil
+	}
+	if at := stack[len(stack)-1].at; at != nil && at.pos != nil && isElement(at, expr) {
+		return at.pos
+	}
+	for i := len(stack) - 1; i >= 0; i-- {
+		if pos := stack[i].Pos(); pos != nil {
+			return pos
+		}
+	}
+	return nil
+}
+
+// isElement reports whether expr is the element of pair, without comparing values Go can't compare.
+func isElement(pair *SexpPair, expr Expression) bool {
+	switch expr.(type) {
+	case Symbol, *RenamedSymbol:
+		return pair.val == expr
+	}
+	return false
+}
+
+// errorExpr is an expression which fails with an error when evaluated, so an error can be raised once the stack has been unwound.
+type errorExpr struct {
+	err *EvalError
+}
+
+func (e errorExpr) Eval(_ *Stack, _ *Env) (result Expression, nextEnv *Env, err error) {
+	return nil, nil, e.err
+}
+
+func (e errorExpr) String() string {
+	return "#<error>"
+}
+
+func (_ errorExpr) IsLiteral() bool {
+	return false
+}
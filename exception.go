@@ -0,0 +1,183 @@
+package golftalk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrorObject is the condition raised by error.
+// Errors raised by the interpreter itself are handed to exception handlers as ErrorObjects too.
+type ErrorObject struct {
+	Message   string
+	Irritants []Expression
+	Err       *EvalError // what to report if the object goes uncaught
+}
+
+var _ Expression = &ErrorObject{}
+
+func (e *ErrorObject) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return e, env, nil
+}
+
+func (e *ErrorObject) String() string {
+	parts := []string{"#<error", PTString(e.Message).String()}
+	for _, irritant := range e.Irritants {
+		parts = append(parts, elementToString(irritant))
+	}
+	return strings.Join(parts, " ") + ">"
+}
+
+func (_ *ErrorObject) IsLiteral() bool {
+	return true
+}
+
+// raised returns the object handed to exception handlers for the error: whatever the program raised, or else an ErrorObject describing it.
+func (e *EvalError) raised() Expression {
+	if e.Raised == nil {
+		e.Raised = &ErrorObject{Message: e.Message, Err: e}
+	}
+	return e.Raised
+}
+
+// raisedError makes the error which raises obj.
+func raisedError(obj Expression, continuable bool) *EvalError {
+	if errObj, ok := obj.(*ErrorObject); ok && errObj.Err != nil {
+		// Raising an error object again reports the original error if nothing catches it
+		e := *errObj.Err
+		e.Continuable = continuable
+		return &e
+	}
+	return &EvalError{Kind: UserError, Message: fmt.Sprintf("Uncaught exception: %s", SexpToString(obj)), Raised: obj, Continuable: continuable}
+}
+
+// ExceptionHandler runs in the frame of a with-exception-handler once its arguments are evaluated, for as long as its thunk runs.
+type ExceptionHandler struct {
+	Handler Procedure
+}
+
+var _ Procedure = &ExceptionHandler{}
+
+func (h *ExceptionHandler) Run(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, err error) {
+	// The thunk returned normally
+	stack.Pop()
+	return frame.StepInput, frame.CurrentEnv, nil
+}
+
+func (_ *ExceptionHandler) GiveName(name string) {
+	//dont care
+	return
+}
+
+func (_ *ExceptionHandler) String() string {
+	return "#<exception handler>"
+}
+
+func (h *ExceptionHandler) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return h, env, nil
+}
+
+func (_ *ExceptionHandler) IsLiteral() bool {
+	return true
+}
+
+// Guard runs in the frame of a guard form while its body is evaluated.
+type Guard struct {
+	Var     Symbol
+	Clauses []Expression
+}
+
+var _ Procedure = &Guard{}
+
+func (g *Guard) Run(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, err error) {
+	// The body returned normally
+	stack.Pop()
+	return frame.StepInput, frame.CurrentEnv, nil
+}
+
+// handle returns the expression which evaluates the guard's clauses like a cond, raising obj again if no test passes.
+func (g *Guard) handle(obj Expression) Expression {
+	reraise := toCode(PTBool(true), toCode(&GoProc{"raise", raise}, obj))
+	clauses := append(append([]Expression{}, g.Clauses...), reraise)
+	return &SexpPair{CoreFunc(coreCond), toCode(clauses...), false, nil, nil}
+}
+
+func (_ *Guard) GiveName(name string) {
+	//dont care
+	return
+}
+
+func (_ *Guard) String() string {
+	return "#<guard>"
+}
+
+func (g *Guard) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return g, env, nil
+}
+
+func (_ *Guard) IsLiteral() bool {
+	return true
+}
+
+// handlerCall waits on an exception handler called by Stack.raise.
+// While the handler runs, the handler it was installed with and any inside it are out of effect.
+type handlerCall struct {
+	Installed   int // index of the frame which installed the handler
+	Resume      int // height to cut the stack back to when resuming a continuable raise
+	Continuable bool
+}
+
+var _ Procedure = &handlerCall{}
+
+func (h *handlerCall) Run(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, err error) {
+	if !h.Continuable {
+		return nil, nil, errorf(UserError, "Exception handler returned from a non-continuable raise.")
+	}
+
+	// Hand the handler's result back in place of the raise
+	*stack = (*stack)[:h.Resume]
+	return frame.StepInput, frame.CurrentEnv, nil
+}
+
+func (_ *handlerCall) GiveName(name string) {
+	//dont care
+	return
+}
+
+func (_ *handlerCall) String() string {
+	return "#<exception handler call>"
+}
+
+func (h *handlerCall) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return h, env, nil
+}
+
+func (_ *handlerCall) IsLiteral() bool {
+	return true
+}
+
+// raise hands an error to the innermost exception handler or guard in effect, and returns the expression to evaluate next.
+// A guard unwinds the stack down to itself before its clauses are evaluated; other handlers are called on top of the stack, where the error happened.
+// If no handler is in effect, the error is returned as is, once any dynamic-winds have been left.
+func (s *Stack) raise(evalErr *EvalError) (result Expression, nextEnv *Env, err error) {
+	for i := len(*s) - 1; i >= 0; i-- {
+		frame := (*s)[i]
+		switch h := frame.Running.(type) {
+		case *handlerCall:
+			// Skip past the handler being run, and the frames it was installed in
+			i = h.Installed
+		case *ExceptionHandler:
+			call := &handlerCall{Installed: i, Resume: len(*s) - 1, Continuable: evalErr.Continuable}
+			*s = append(*s, StackFrame{Running: call, CurrentEnv: frame.CurrentEnv})
+			return toCode(h.Handler, evalErr.raised()), frame.CurrentEnv, nil
+		case *Guard:
+			obj := evalErr.raised()
+			return s.jump((*s)[:i].Copy(), h.handle(obj), MakeEnv([]Symbol{h.Var}, []Expression{obj}, frame.CurrentEnv))
+		}
+	}
+
+	if len(s.winds()) > 0 {
+		// Leave every dynamic-wind before giving up
+		return s.jump(nil, errorExpr{evalErr}, nil)
+	}
+	return nil, nil, evalErr
+}
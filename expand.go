@@ -0,0 +1,628 @@
+package golftalk
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// expander expands every macro use in a form before the form is evaluated, so the evaluator only ever sees core forms and procedure calls.
+// It follows scope as it goes, using Envs of its own that bind variables to PTBlank and macros to themselves, so a variable can shadow a macro and macros defined in a body are only seen there.
+type expander struct {
+	// base is the Env the form will be evaluated in; macros defined in it stay defined for later forms
+	base    *Env
+	watcher stepWatcher
+}
+
+// maxExpansionDepth bounds how deeply macro uses may expand into further macro uses, whatever the limits.
+// The expander recurses in Go, and running out of Go stack can't be recovered from.
+const maxExpansionDepth = 10000
+
+// expandMacros returns expr with every macro use in it expanded, as seen from env.
+// Each expansion is shown to watcher, if it isn't nil, so limits cover expansion too.
+func expandMacros(expr Expression, env *Env, watcher stepWatcher) (Expression, error) {
+	x := &expander{env, watcher}
+	return x.expand(expr, env, 0)
+}
+
+// lookupSyntax finds what an identifier means where it's being expanded.
+// Identifiers a macro introduced, and which weren't bound by its expansion, mean what they did where the macro was defined.
+func lookupSyntax(id Expression, scope *Env) Expression {
+	for {
+		name, _ := bindingName(id)
+		if val, err := scope.Get(name); err == nil {
+			return val
+		}
+		renamed, ok := id.(*RenamedSymbol)
+		if !ok {
+			return nil
+		}
+		id, scope = renamed.Orig, renamed.Env
+	}
+}
+
+// newScope makes a scope within scope for the expansion of a body.
+func newScope(scope *Env) *Env {
+	inner := NewEnv()
+	inner.Outer = scope
+	return inner
+}
+
+// bindVariable notes that a variable is bound in scope, hiding any macro of the same name.
+// Variables defined in the base Env are left to the evaluator to bind.
+func (x *expander) bindVariable(scope *Env, id Expression) {
+	if name, ok := bindingName(id); ok && scope != x.base {
+		scope.Dict[name] = PTBlank
+	}
+}
+
+// newAnchor makes a name to bind where a macro is defined in a body, so its renamed identifiers can find that scope again when they're evaluated.
+// The space keeps it from ever colliding with a symbol the reader could produce.
+func newAnchor() Symbol {
+	return Symbol(fmt.Sprintf("macro scope %d", atomic.AddInt64(&renameCounter, 1)))
+}
+
+func (x *expander) expand(expr Expression, scope *Env, depth int) (Expression, error) {
+	for {
+		if isIdentifier(expr) {
+			if macro, isMacro := lookupSyntax(expr, scope).(*Macro); isMacro {
+				return nil, annotate(errorf(SyntaxError, "Bad syntax: macro '%s' can't be used as a value.", macro.displayName()), expr, nil)
+			}
+			return expr, nil
+		}
+
+		form, ok := expr.(*SexpPair)
+		if !ok || form == EmptyList || form.literal {
+			return expr, nil
+		}
+		if !isIdentifier(form.val) {
+			return x.expandList(form, scope, depth)
+		}
+
+		switch keyword := lookupSyntax(form.val, scope).(type) {
+		case *Macro:
+			if depth >= maxExpansionDepth {
+				return nil, annotate(errorf(SyntaxError, "Bad syntax: macro expansion nested deeper than %d.", maxExpansionDepth), form, nil)
+			}
+			if x.watcher != nil {
+				if err := x.watcher.beforeExpand(form, depth); err != nil {
+					return nil, err
+				}
+			}
+			args, argsOk := form.next.(*SexpPair)
+			if !argsOk {
+				args = &SexpPair{form.next, EmptyList, false, nil, nil}
+			}
+			expansion, err := keyword.Expand(args)
+			if err != nil {
+				return nil, annotate(err, form, nil)
+			}
+			if pair, isPair := expansion.(*SexpPair); isPair && pair != EmptyList && pair.open == nil {
+				pair.pos, pair.open = form.pos, form.open
+			}
+			expr, depth = expansion, depth+1
+		case CoreFunc:
+			return x.expandForm(baseSymbol(form.val), form, scope, depth)
+		default:
+			return x.expandList(form, scope, depth)
+		}
+	}
+}
+
+// expandList expands each element of a list, which is a procedure call or a form whose parts are all expressions.
+func (x *expander) expandList(list *SexpPair, scope *Env, depth int) (Expression, error) {
+	return mapList(list, func(elem Expression) (Expression, error) {
+		return x.expand(elem, scope, depth)
+	})
+}
+
+// expandBody expands the expressions of a body in order, in scope, so what they define is seen by the ones after.
+func (x *expander) expandBody(body Expression, scope *Env, depth int) (Expression, error) {
+	list, ok := body.(*SexpPair)
+	if !ok {
+		return body, nil
+	}
+	return x.expandList(list, scope, depth)
+}
+
+// mapList copies a list with fn applied to each element, and to the tail if it's improper.
+func mapList(list *SexpPair, fn func(Expression) (Expression, error)) (Expression, error) {
+	if list == EmptyList {
+		return list, nil
+	}
+	head, err := fn(list.val)
+	if err != nil {
+		return nil, err
+	}
+
+	var tail Expression
+	if next, ok := list.next.(*SexpPair); ok {
+		tail, err = mapList(next, fn)
+	} else {
+		tail, err = fn(list.next)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &SexpPair{head, tail, list.literal, list.pos, list.open}, nil
+}
+
+// rebuild copies form with its arguments replaced, keeping the positions of the pairs that held them.
+// The last of args is the rest of the list after the others.
+func rebuild(form *SexpPair, args ...Expression) *SexpPair {
+	head := *form
+	at, orig := &head, form
+	for i, arg := range args {
+		if i == len(args)-1 {
+			at.next = arg
+			break
+		}
+		next := *orig.next.(*SexpPair)
+		next.val = arg
+		at.next = &next
+		at, orig = &next, orig.next.(*SexpPair)
+	}
+	return &head
+}
+
+// letKinds are the kinds of let form, by name.
+var letKinds = map[Symbol]letKind{
+	"let":     letParallel,
+	"let*":    letSequential,
+	"letrec":  letRecursive,
+	"letrec*": letRecursiveSequential,
+}
+
+// expandForm expands a core form.
+// Forms that bind variables get scopes of their own, and forms with parts that aren't expressions are taken apart.
+// Anything malformed is left for the evaluator to complain about, except for macro definitions, which are dealt with entirely here.
+func (x *expander) expandForm(keyword Symbol, form *SexpPair, scope *Env, depth int) (Expression, error) {
+	args, ok := form.next.(*SexpPair)
+	if !ok || args == EmptyList {
+		return x.expandList(form, scope, depth)
+	}
+
+	switch keyword {
+	case "quote", "this-guy", "syntax-rules":
+		return form, nil
+
+	case "quasiquote":
+		template, err := x.expandQuasi(args.val, 0, scope, depth)
+		if err != nil {
+			return nil, err
+		}
+		return rebuild(form, template, args.next), nil
+
+	case "define", "yknow":
+		signature, isPair := args.val.(*SexpPair)
+		if !isPair || signature == EmptyList || signature.literal {
+			x.bindVariable(scope, args.val)
+			value, err := x.expandBody(args.next, scope, depth)
+			if err != nil {
+				return nil, err
+			}
+			return rebuild(form, args.val, value), nil
+		}
+
+		// (define (name . params) body...)
+		x.bindVariable(scope, signature.val)
+		clause, err := x.expandClause(&SexpPair{signature.next, args.next, false, nil, nil}, scope, depth)
+		if err != nil || clause == nil {
+			return form, err
+		}
+		return rebuild(form, rebuild(signature, clause.val), clause.next), nil
+
+	case "lambda", "bring-me-back-something-good":
+		clause, err := x.expandClause(args, scope, depth)
+		if err != nil || clause == nil {
+			return form, err
+		}
+		return rebuild(form, clause), nil
+
+	case "case-lambda":
+		clauses, err := mapList(args, func(clause Expression) (Expression, error) {
+			pair, isPair := clause.(*SexpPair)
+			if !isPair || pair == EmptyList {
+				return clause, nil
+			}
+			expanded, err := x.expandClause(pair, scope, depth)
+			if err != nil || expanded == nil {
+				return clause, err
+			}
+			return expanded, nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return rebuild(form, clauses), nil
+
+	case "let", "let*", "letrec", "letrec*":
+		return x.expandLet(letKinds[keyword], form, args, scope, depth)
+
+	case "let-values":
+		return x.expandLetValues(form, args, scope, depth)
+
+	case "cond":
+		clauses, err := mapList(args, func(clause Expression) (Expression, error) {
+			return x.expandCondClause(clause, scope, depth)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return rebuild(form, clauses), nil
+
+	case "guard":
+		return x.expandGuard(form, args, scope, depth)
+
+	case "define-record-type":
+		specs, listErr := ToSlice(args)
+		if listErr != nil || len(specs) < 3 {
+			return form, nil
+		}
+		x.bindVariable(scope, specs[0])
+		x.bindVariable(scope, specs[2])
+		if ctor, isPair := specs[1].(*SexpPair); isPair && ctor != EmptyList {
+			x.bindVariable(scope, ctor.val)
+		}
+		for _, spec := range specs[3:] {
+			if field, isPair := spec.(*SexpPair); isPair {
+				if parts, partsErr := ToSlice(field); partsErr == nil && len(parts) > 1 {
+					for _, part := range parts[1:] {
+						x.bindVariable(scope, part)
+					}
+				}
+			}
+		}
+		return form, nil
+
+	case "define-syntax":
+		return x.expandDefineSyntax(form, args, scope)
+
+	case "let-syntax", "letrec-syntax":
+		return x.expandLetSyntax(keyword == "letrec-syntax", form, args, scope, depth)
+	}
+
+	return x.expandList(form, scope, depth)
+}
+
+// expandClause expands a parameter list and the body that follows it, as in a lambda, in a scope where the parameters are bound.
+// It returns nil if the parameters are malformed.
+func (x *expander) expandClause(clause *SexpPair, scope *Env, depth int) (*SexpPair, error) {
+	proc, err := parseParams(clause.val)
+	if err != nil {
+		return nil, nil
+	}
+	inner := newScope(scope)
+	x.bindParams(proc, inner)
+
+	// Defaults of optional parameters are the only expressions among the parameters
+	params := clause.val
+	if list, isPair := params.(*SexpPair); isPair {
+		params, err = mapList(list, func(param Expression) (Expression, error) {
+			withDefault, isPair := param.(*SexpPair)
+			if !isPair || withDefault == EmptyList {
+				return param, nil
+			}
+			def := withDefault.next.(*SexpPair)
+			expanded, err := x.expand(def.val, inner, depth)
+			if err != nil {
+				return nil, err
+			}
+			return rebuild(withDefault, expanded, def.next), nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	body, err := x.expandBody(clause.next, inner, depth)
+	if err != nil {
+		return nil, err
+	}
+	return &SexpPair{params, body, clause.literal, clause.pos, clause.open}, nil
+}
+
+// bindParams notes the parameters of proc as bound in scope.
+func (x *expander) bindParams(proc *Proc, scope *Env) {
+	for _, name := range proc.Vars {
+		x.bindVariable(scope, name)
+	}
+	for _, opt := range proc.Optional {
+		x.bindVariable(scope, opt.Name)
+	}
+	if proc.Rest != "" {
+		x.bindVariable(scope, proc.Rest)
+	}
+}
+
+// expandLet expands a let form, with each binding's value expanded in the scope it'll be evaluated in.
+func (x *expander) expandLet(kind letKind, form *SexpPair, args *SexpPair, scope *Env, depth int) (Expression, error) {
+	if _, _, _, _, err := parseLet(args, kind); err != nil {
+		return form, nil
+	}
+
+	inner := newScope(scope)
+	var name Expression
+	if kind == letParallel && isIdentifier(args.val) {
+		// A named let's procedure is seen only in its body
+		name, args = args.val, args.next.(*SexpPair)
+	}
+
+	initScope := scope
+	if kind != letParallel {
+		initScope = inner
+	}
+	bindingList := args.val.(*SexpPair)
+	if kind == letRecursive || kind == letRecursiveSequential {
+		bindings, _ := ToSlice(bindingList)
+		for _, binding := range bindings {
+			x.bindVariable(inner, binding.(*SexpPair).val)
+		}
+	}
+
+	bindings, err := mapList(bindingList, func(b Expression) (Expression, error) {
+		binding := b.(*SexpPair)
+		init := binding.next.(*SexpPair)
+		expanded, err := x.expand(init.val, initScope, depth)
+		if err != nil {
+			return nil, err
+		}
+		if kind == letSequential {
+			x.bindVariable(inner, binding.val)
+		}
+		return rebuild(binding, expanded, init.next), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if kind == letParallel {
+		for b, ok := bindingList, true; ok && b != EmptyList; b, ok = b.next.(*SexpPair) {
+			x.bindVariable(inner, b.val.(*SexpPair).val)
+		}
+	}
+
+	if name != nil {
+		x.bindVariable(inner, name)
+	}
+	body, err := x.expandBody(args.next, inner, depth)
+	if err != nil {
+		return nil, err
+	}
+	if name != nil {
+		return rebuild(form, name, bindings, body), nil
+	}
+	return rebuild(form, bindings, body), nil
+}
+
+// expandLetValues expands a let-values form, whose values are expanded outside the scope of its formals.
+func (x *expander) expandLetValues(form *SexpPair, args *SexpPair, scope *Env, depth int) (Expression, error) {
+	bindingList, isPair := args.val.(*SexpPair)
+	if !isPair || bindingList.literal {
+		return form, nil
+	}
+	if _, listErr := ToSlice(bindingList); listErr != nil {
+		return form, nil
+	}
+
+	inner := newScope(scope)
+	bindings, err := mapList(bindingList, func(b Expression) (Expression, error) {
+		binding, isPair := b.(*SexpPair)
+		if length, _ := binding.Len(); !isPair || length != 2 || binding.literal {
+			return b, nil
+		}
+		if formals, paramsErr := parseParams(binding.val); paramsErr == nil {
+			x.bindParams(formals, inner)
+		}
+		init := binding.next.(*SexpPair)
+		expanded, err := x.expand(init.val, scope, depth)
+		if err != nil {
+			return nil, err
+		}
+		return rebuild(binding, expanded, init.next), nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := x.expandBody(args.next, inner, depth)
+	if err != nil {
+		return nil, err
+	}
+	return rebuild(form, bindings, body), nil
+}
+
+// expandCondClause expands a cond clause, whose body has a scope of its own.
+func (x *expander) expandCondClause(clause Expression, scope *Env, depth int) (Expression, error) {
+	pair, isPair := clause.(*SexpPair)
+	if !isPair || pair == EmptyList || pair.literal {
+		return clause, nil
+	}
+	test, err := x.expand(pair.val, scope, depth)
+	if err != nil {
+		return nil, err
+	}
+	body, err := x.expandBody(pair.next, newScope(scope), depth)
+	if err != nil {
+		return nil, err
+	}
+	return &SexpPair{test, body, pair.literal, pair.pos, pair.open}, nil
+}
+
+// expandGuard expands (guard (var clause...) body...), whose clauses see var and whose body doesn't.
+func (x *expander) expandGuard(form *SexpPair, args *SexpPair, scope *Env, depth int) (Expression, error) {
+	spec, isPair := args.val.(*SexpPair)
+	if !isPair || spec == EmptyList || spec.literal || !isIdentifier(spec.val) {
+		return form, nil
+	}
+	clauseList, isPair := spec.next.(*SexpPair)
+	if !isPair {
+		return form, nil
+	}
+
+	inner := newScope(scope)
+	x.bindVariable(inner, spec.val)
+	clauses, err := mapList(clauseList, func(clause Expression) (Expression, error) {
+		return x.expandCondClause(clause, inner, depth)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := x.expandBody(args.next, scope, depth)
+	if err != nil {
+		return nil, err
+	}
+	return rebuild(form, rebuild(spec, clauses), body), nil
+}
+
+// expandQuasi expands the unquoted parts of a quasiquote template.
+// Only unquotes at level 0 are evaluated; nested quasiquotes go a level deeper.
+func (x *expander) expandQuasi(template Expression, level int, scope *Env, depth int) (Expression, error) {
+	pair, isPair := template.(*SexpPair)
+	if !isPair || pair == EmptyList || pair.literal {
+		return template, nil
+	}
+
+	for _, name := range []Symbol{"unquote", "unquote-splicing", "quasiquote"} {
+		arg, ok := isQuasiForm(pair, name)
+		if !ok {
+			continue
+		}
+		var expanded Expression
+		var err error
+		switch {
+		case name == "quasiquote":
+			expanded, err = x.expandQuasi(arg, level+1, scope, depth)
+		case level == 0:
+			expanded, err = x.expand(arg, scope, depth)
+		default:
+			expanded, err = x.expandQuasi(arg, level-1, scope, depth)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return rebuild(pair, expanded, EmptyList), nil
+	}
+
+	head, err := x.expandQuasi(pair.val, level, scope, depth)
+	if err != nil {
+		return nil, err
+	}
+	tail, err := x.expandQuasi(pair.next, level, scope, depth)
+	if err != nil {
+		return nil, err
+	}
+	return &SexpPair{head, tail, pair.literal, pair.pos, pair.open}, nil
+}
+
+// transformer makes the macro a define-syntax or let-syntax binds an identifier to, given its transformer spec.
+// The spec is syntax-rules, or the name of another macro. It returns nil if it's neither.
+func (x *expander) transformer(spec Expression, scope *Env, defScope *Env, anchor Symbol) (*Macro, error) {
+	if isIdentifier(spec) {
+		macro, _ := lookupSyntax(spec, scope).(*Macro)
+		return macro, nil
+	}
+
+	rules, isPair := spec.(*SexpPair)
+	if !isPair || rules == EmptyList || rules.literal || !isIdentifier(rules.val) || baseSymbol(rules.val) != "syntax-rules" {
+		return nil, nil
+	}
+	if _, isCore := lookupSyntax(rules.val, scope).(CoreFunc); !isCore {
+		return nil, nil
+	}
+	args, isPair := rules.next.(*SexpPair)
+	if !isPair {
+		return nil, annotate(errorf(SyntaxError, "syntax-rules needs a list of literals."), spec, nil)
+	}
+	macro, err := NewSyntaxRules(args, defScope)
+	if err != nil {
+		return nil, annotate(err, spec, nil)
+	}
+	macro.anchor = anchor
+	return macro, nil
+}
+
+// expandDefineSyntax binds a macro in scope.
+// Defined in the base Env, it stays defined for later forms, and the form itself does nothing.
+// Defined in a body, it leaves behind a definition of its anchor.
+func (x *expander) expandDefineSyntax(form *SexpPair, args *SexpPair, scope *Env) (Expression, error) {
+	if !isIdentifier(args.val) {
+		return nil, annotate(errorf(SyntaxError, "Symbol given to define-syntax wasn't a symbol."), form, nil)
+	}
+	if length, _ := args.Len(); length != 2 {
+		return nil, annotate(errorf(SyntaxError, "define-syntax takes a symbol and a syntax transformer."), form, nil)
+	}
+
+	var anchor Symbol
+	if scope != x.base {
+		anchor = newAnchor()
+	}
+	macro, err := x.transformer(Get(args, 1), scope, scope, anchor)
+	if err != nil {
+		return nil, err
+	}
+	if macro == nil {
+		return nil, annotate(errorf(SyntaxError, "define-syntax needs a syntax transformer, such as syntax-rules."), form, nil)
+	}
+
+	name, _ := bindingName(args.val)
+	macro.GiveName(string(baseSymbol(args.val)))
+	scope.Dict[name] = macro
+	if anchor == "" || macro.anchor != anchor {
+		return PTBlank, nil
+	}
+	return toCode(CoreFunc(coreDefine), anchor, PTBlank), nil
+}
+
+// expandLetSyntax binds macros in a new scope for a body, which becomes a let.
+// If recursive, the macros are defined within that scope, so they can refer to each other.
+func (x *expander) expandLetSyntax(recursive bool, form *SexpPair, args *SexpPair, scope *Env, depth int) (Expression, error) {
+	bindingList, isPair := args.val.(*SexpPair)
+	if !isPair {
+		return nil, annotate(errorf(SyntaxError, "First argument to let-syntax must be a list of bindings."), form, nil)
+	}
+	bindings, listErr := ToSlice(bindingList)
+	if listErr != nil {
+		return nil, annotate(errorf(SyntaxError, "let-syntax: binding list not a list"), form, nil)
+	}
+
+	inner := newScope(scope)
+	defScope := scope
+	if recursive {
+		defScope = inner
+	}
+	var anchor Symbol
+	if defScope != x.base {
+		anchor = newAnchor()
+	}
+	for i, b := range bindings {
+		binding, isPair := b.(*SexpPair)
+		if length, _ := binding.Len(); !isPair || length != 2 || !isIdentifier(binding.val) {
+			return nil, annotate(errorf(SyntaxError, "Binding #%d of let-syntax must be a symbol and a transformer.", i+1), form, nil)
+		}
+		macro, err := x.transformer(Get(binding, 1), defScope, defScope, anchor)
+		if err != nil {
+			return nil, err
+		}
+		if macro == nil {
+			return nil, annotate(errorf(TypeError, "Binding #%d of let-syntax is not a syntax transformer.", i+1), form, nil)
+		}
+		name, _ := bindingName(binding.val)
+		macro.GiveName(string(baseSymbol(binding.val)))
+		inner.Dict[name] = macro
+	}
+
+	var body Expression = toCode(PTBlank)
+	if args.next != EmptyList {
+		var err error
+		if body, err = x.expandBody(args.next, newScope(inner), depth); err != nil {
+			return nil, err
+		}
+	}
+
+	// The body gets a scope of its own, so what it defines is kept from the macros' templates, which see only the scope around it
+	let := &SexpPair{CoreFunc(coreLet), &SexpPair{EmptyList, body, false, nil, nil}, false, form.pos, form.open}
+	if anchor == "" {
+		return let, nil
+	}
+	return &SexpPair{CoreFunc(coreLet), toCode(toCode(toCode(anchor, PTBlank)), let), false, form.pos, form.open}, nil
+}
@@ -1,11 +1,14 @@
-package main
+package golftalk
 
 import (
 	"fmt"
+	"math/big"
+	"strings"
+	"unicode"
 )
 
 type Expression interface {
-	Eval(stack *Stack, env *Env) (result Expression, nextEnv *Env, err string)
+	Eval(stack *Stack, env *Env) (result Expression, nextEnv *Env, err error)
 	String() string
 	IsLiteral() bool
 }
@@ -15,12 +18,12 @@ type Symbol string
 //Symbol should implement Expression
 var _ Expression = Symbol("")
 
-func (s Symbol) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err string) {
+func (s Symbol) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
 	lookup, lookupErr := env.Get(s)
 	if lookupErr != nil {
-		return nil, nil, lookupErr.Error()
+		return nil, nil, errorf(UnboundError, "%s", lookupErr)
 	}
-	return lookup, env, ""
+	return lookup, env, nil
 }
 
 func (s Symbol) String() string {
@@ -36,8 +39,8 @@ type PTInt int
 //PTInt should implement Expression
 var _ Expression = PTInt(0)
 
-func (i PTInt) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err string) {
-	return i, env, ""
+func (i PTInt) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return i, env, nil
 }
 
 func (i PTInt) String() string {
@@ -48,13 +51,55 @@ func (_ PTInt) IsLiteral() bool {
 	return true
 }
 
+// PTBigInt holds integers too large for a PTInt.
+// Arithmetic promotes to it on overflow, and demotes back to PTInt whenever the result fits, so a PTBigInt never holds a value a PTInt could.
+type PTBigInt struct {
+	Int *big.Int
+}
+
+//PTBigInt should implement Expression
+var _ Expression = PTBigInt{}
+
+func (i PTBigInt) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return i, env, nil
+}
+
+func (i PTBigInt) String() string {
+	return i.Int.String()
+}
+
+func (_ PTBigInt) IsLiteral() bool {
+	return true
+}
+
+// PTRational holds exact fractions such as 1/3.
+// Like PTBigInt, it is always normalized: rationals with a denominator of 1 become integers.
+type PTRational struct {
+	Rat *big.Rat
+}
+
+//PTRational should implement Expression
+var _ Expression = PTRational{}
+
+func (r PTRational) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return r, env, nil
+}
+
+func (r PTRational) String() string {
+	return r.Rat.String()
+}
+
+func (_ PTRational) IsLiteral() bool {
+	return true
+}
+
 type PTFloat float64
 
 //PTFloat should implement Expression
 var _ Expression = PTFloat(0.0)
 
-func (f PTFloat) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err string) {
-	return f, env, ""
+func (f PTFloat) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return f, env, nil
 }
 
 func (f PTFloat) String() string {
@@ -70,8 +115,8 @@ type PTBool bool
 //PTBool should implement Expression
 var _ Expression = PTBool(false)
 
-func (b PTBool) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err string) {
-	return b, env, ""
+func (b PTBool) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return b, env, nil
 }
 
 func (b PTBool) String() string {
@@ -85,10 +130,42 @@ func (_ PTBool) IsLiteral() bool {
 	return true
 }
 
+type PTString string
+
+//PTString should implement Expression
+var _ Expression = PTString("")
+
+func (s PTString) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return s, env, nil
+}
+
+// String writes the string as a literal the reader reads back as the same string.
+// Characters the reader has an escape for are written with it, and any other control characters as hex escapes.
+func (s PTString) String() string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for _, r := range s {
+		if esc, ok := stringEscapeNames[r]; ok {
+			b.WriteByte('\\')
+			b.WriteRune(esc)
+		} else if unicode.IsControl(r) {
+			fmt.Fprintf(&b, "\\x%x;", r)
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+func (_ PTString) IsLiteral() bool {
+	return true
+}
+
 type QuotedSymbol string
 
-func (s QuotedSymbol) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err string) {
-	return s, env, ""
+func (s QuotedSymbol) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return s, env, nil
 }
 
 func (s QuotedSymbol) String() string {
@@ -99,13 +176,68 @@ func (_ QuotedSymbol) IsLiteral() bool {
 	return true
 }
 
+// PTVector is a fixed-length sequence of values with constant-time indexing.
+// Vectors can be changed in place, so they're always handled by pointer.
+type PTVector struct {
+	Items []Expression
+
+	// constant is set on vectors read from source, which are part of the program and so can't be changed
+	constant bool
+}
+
+func (v *PTVector) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return v, env, nil
+}
+
+func (v *PTVector) String() string {
+	parts := make([]string, len(v.Items))
+	for i, item := range v.Items {
+		parts[i] = elementToString(item)
+	}
+	return "#(" + strings.Join(parts, " ") + ")"
+}
+
+func (_ *PTVector) IsLiteral() bool {
+	return true
+}
+
+// PTValues holds the results of an expression which returns other than one value, as made by values.
+// It's handled by pointer, like a vector, so eq? can compare it.
+type PTValues struct {
+	Items []Expression
+}
+
+func (v *PTValues) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return v, env, nil
+}
+
+func (v *PTValues) String() string {
+	parts := make([]string, len(v.Items))
+	for i, val := range v.Items {
+		parts[i] = SexpToString(val)
+	}
+	return strings.Join(parts, " ")
+}
+
+func (_ *PTValues) IsLiteral() bool {
+	return true
+}
+
+// Values unpacks the result of an expression into the values it returned.
+func Values(result Expression) []Expression {
+	if vals, ok := result.(*PTValues); ok {
+		return vals.Items
+	}
+	return []Expression{result}
+}
+
 //Used only to have some special functions be able to return nothing
 type PTBlankType struct{}
 
 var PTBlank Expression = PTBlankType{}
 
-func (_ PTBlankType) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err string) {
-	return PTBlank, env, ""
+func (_ PTBlankType) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return PTBlank, env, nil
 }
 
 func (_ PTBlankType) String() string {
@@ -0,0 +1,3 @@
+module github.com/bitbanger/golftalk
+
+go 1.16
@@ -1,23 +1,23 @@
-package main
+package golftalk
 
 import (
-	"bufio"
 	"container/list"
 	"errors"
 	"fmt"
 	"io"
 	"os"
 	"regexp"
+	"strings"
 )
 
-// Should we use the actual Scheme names?
-var USE_SCHEME_NAMES bool = true
-
 // Env represents an "environment": a scope's mapping of symbol strings to values.
 // Env also provides the ability to search up a scope chain for a value.
 type Env struct {
 	Dict  map[Symbol]Expression
 	Outer *Env
+	Proc  *Proc // the procedure whose call made this Env, if any
+
+	ports *outputPorts // set on global Envs only
 }
 
 type SymbolNotFoundError Symbol
@@ -34,6 +34,18 @@ func (e *Env) Get(val Symbol) (result Expression, err error) {
 	return nil, SymbolNotFoundError(val)
 }
 
+// Set rebinds a symbol in the nearest scope along the chain in which it's bound.
+// Unlike a define, Set never creates a new binding.
+func (e *Env) Set(sym Symbol, val Expression) error {
+	for tmpEnv := e; tmpEnv != nil; tmpEnv = tmpEnv.Outer {
+		if _, ok := tmpEnv.Dict[sym]; ok {
+			tmpEnv.Dict[sym] = val
+			return nil
+		}
+	}
+	return SymbolNotFoundError(sym)
+}
+
 // NewEnv returns an initialized environment.
 func NewEnv() *Env {
 	env := &Env{}
@@ -82,113 +94,166 @@ func SexpToString(sexp Expression) string {
 	return sexp.String()
 }
 
+// Repr formats a value the way the REPL shows it: lists are quoted, as they'd need to be to be typed back in.
+func Repr(val Expression) string {
+	if list, ok := val.(*SexpPair); ok && (list == EmptyList || list.literal) {
+		return "'" + SexpToString(list)
+	}
+	return SexpToString(val)
+}
+
 // Eval takes an S-expression and an environment, and returns the most simplified equivalent S-expression.
 // Possible ways to simplify an S-expression include returning a literal value if the input was simply that literal value, looking up a symbol in the given environment (and its implied scope chain), and interpreting the S-expression as a function invocation.
 // In the lattermost of evaluation strategies, the function may be provided as a literal or as a symbol referring to a function in the given scope chain; in other words, the first argument has Eval recursively applied to it and must yield a function.
-// If an error occurs at any point in the evaluation, Eval returns an error string, and the returned value should be disregarded.
-func Eval(inVal Expression, inEnv *Env) (Expression, string) {
+// If an error occurs at any point in the evaluation, Eval returns an *EvalError describing it and the call stack at the time, and the returned value should be disregarded.
+func Eval(inVal Expression, inEnv *Env) (Expression, error) {
+	return evalWatched(inVal, inEnv, nil)
+}
+
+// stepWatcher is shown each step of an evaluation before it's taken, as Debugger, Profiler and limits are.
+// If it returns an error, evaluation stops with that error, and exception handlers don't get a say.
+type stepWatcher interface {
+	beforeStep(expr Expression, env *Env, stack Stack) error
+	// beforeExpand is shown each macro use before it's expanded, depth being how many expansions it's nested in
+	beforeExpand(form Expression, depth int) error
+	// done is called once the evaluation is over, however it ended
+	done()
+}
+
+// stepWatchers lets several watchers watch the same evaluation.
+type stepWatchers []stepWatcher
+
+func (ws stepWatchers) beforeStep(expr Expression, env *Env, stack Stack) error {
+	for _, w := range ws {
+		if err := w.beforeStep(expr, env, stack); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ws stepWatchers) beforeExpand(form Expression, depth int) error {
+	for _, w := range ws {
+		if err := w.beforeExpand(form, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ws stepWatchers) done() {
+	for _, w := range ws {
+		w.done()
+	}
+}
+
+// evalWatched is Eval, with every step shown to watcher first if it isn't nil.
+func evalWatched(inVal Expression, inEnv *Env, watcher stepWatcher) (result Expression, err error) {
 	expr := inVal
 	env := inEnv
+	var offending Expression // what the current step is blamed on if it fails
 
 	var stack Stack = make([]StackFrame, 0, 10)
+	if watcher != nil {
+		defer watcher.done()
+	}
+	defer func() {
+		// A bug in the interpreter fails this evaluation, rather than the whole program
+		if r := recover(); r != nil {
+			result, err = nil, annotate(errorf(InternalError, "%v", r), offending, stack)
+		}
+	}()
+
+	// Macros are all expanded before anything is evaluated
+	expr, err = expandMacros(inVal, inEnv, watcher)
+	if err != nil {
+		return nil, err
+	}
 
 	for {
-		var err string
+		var err error
+		offending = expr
+
+		if watcher != nil {
+			if err = watcher.beforeStep(expr, env, stack); err != nil {
+				return nil, err
+			}
+		}
 
 		if expr.IsLiteral() {
 			//Don't bother evaluating it
 
 			if len(stack) == 0 {
 				// Finally done
-				return expr, ""
+				return expr, nil
 			}
 
 			// Not done, hand it back to the top procedure on the stack
+			if call := stack[len(stack)-1].Call; call != nil {
+				offending = call
+			}
 			expr, env, err = (&stack).RunTop(expr)
 		} else {
 			expr, env, err = expr.Eval(&stack, env)
 		}
-		if err != "" {
-			return expr, err
+		if err == ErrExit {
+			// Not even exception handlers get in the way of exit
+			return nil, err
+		}
+		if err != nil {
+			// Give the program a chance to handle it
+			expr, env, err = (&stack).raise(annotate(err, offending, stack))
+			if err != nil {
+				return nil, err
+			}
 		}
 	}
-
-	return nil, "Eval is seriously broken."
 }
 
-// InitGlobalEnv initializes the hierarchichal "root" environment with a few built-in functions and constants.
+// InitGlobalEnv initializes the hierarchichal "root" environment with a few built-in functions and constants, using the actual Scheme names for some, and writing output to stdout.
 func InitGlobalEnv(globalEnv *Env) {
+	initGlobalEnv(globalEnv, true, os.Stdout)
+}
+
+// initGlobalEnv is InitGlobalEnv, with the choice of whether to use the actual Scheme names too, and where output goes.
+func initGlobalEnv(globalEnv *Env, schemeNames bool, output io.Writer) {
 	globalEnv.Dict["pi"] = PTFloat(3.141592653589793)
 	globalEnv.Dict["euler"] = PTFloat(2.718281828459045)
 
 	//insert library functions written in go
-	for name, ptr := range goLibraryProcs {
+	for name, ptr := range goLibraryProcs() {
 		globalEnv.Dict[Symbol(name)] = &GoProc{name, ptr}
 	}
 
+	// Ports belong to the interpreter, so the procedures that get at them are made for each global Env
+	ports := &outputPorts{Output: &PTPort{output}}
+	ports.Trace = ports.Output
+	globalEnv.ports = ports
+	globalEnv.Dict["current-output-port"] = &GoProc{"current-output-port", ports.currentOutputPort}
+	globalEnv.Dict["trace-output-port"] = &GoProc{"trace-output-port", ports.traceOutputPort}
+	globalEnv.Dict["display"] = &GoProc{"display", ports.display}
+	globalEnv.Dict["write"] = &GoProc{"write", ports.write}
+	globalEnv.Dict["newline"] = &GoProc{"newline", ports.newline}
+
 	//insert core functions defined in core_func.go
 	for name, ptr := range coreFuncs {
 		globalEnv.Dict[name] = ptr
 	}
 
 	//insert library functions written in proftalk
-	libraryExprs, _ := ParseLine(libraryCode)
+	libraryScanner := NewScanner(strings.NewReader(libraryCode))
+	libraryScanner.File = "library"
+	libraryExprs, _ := Parse(libraryScanner)
 	for _, expr := range libraryExprs {
 		_, err := Eval(expr, globalEnv)
-		if err != "" {
+		if err != nil {
 			panic(errors.New(fmt.Sprintf("error in library expression: '%s'\nExpression:\n%s", err, SexpToString(expr))))
 		}
 	}
 
-	if USE_SCHEME_NAMES {
+	if schemeNames {
 		for name, mapping := range alternateNames {
 			globalEnv.Dict[Symbol(name)], _ = Eval(Symbol(mapping), globalEnv)
 		}
 	}
 }
-
-func main() {
-	globalEnv := NewEnv()
-
-	InitGlobalEnv(globalEnv)
-
-	in := bufio.NewReader(os.Stdin)
-
-	for {
-		fmt.Print("golftalk~$ ")
-		line, err := in.ReadString('\n')
-
-		if err != nil {
-			if err == io.EOF {
-				fmt.Println("\n\nhave a nice day ;)")
-				break
-			} else {
-				panic(err)
-			}
-		}
-
-		if line != "" && line != "\n" {
-			sexps, parseErr := ParseLine(line)
-			if parseErr != nil {
-				fmt.Printf("No.\n\t%s\n", parseErr.Error())
-				continue
-			}
-
-			for _, sexp := range sexps {
-				result, evalErr := Eval(sexp, globalEnv)
-
-				if evalErr != "" {
-					fmt.Printf("No.\n\t%s\n", evalErr)
-					continue
-				}
-
-				if result != nil {
-					if sexpResult, ok := result.(*SexpPair); ok && (sexpResult == EmptyList || sexpResult.literal) {
-						fmt.Print("'")
-					}
-					fmt.Println(SexpToString(result))
-				}
-			}
-		}
-	}
-}
@@ -1,6 +1,15 @@
-package main
+package golftalk
 
-import "testing"
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
 
 var emptyEnv *Env = NewEnv()
 
@@ -19,7 +28,7 @@ func evalExpectInt(t *testing.T, expr string, expect int, env *Env) {
 
 	//TODO: fix this to do something more sensible than just eval the first one
 	x, err := Eval(sexps[0], env)
-	if err != "" {
+	if err != nil {
 		t.Error(expr, "gives error:", err)
 		return
 	}
@@ -53,7 +62,7 @@ func evalExpectBool(t *testing.T, expr string, expect bool, env *Env) {
 
 	//TODO: fix this to do something more sensible than just eval the first one
 	x, err := Eval(sexps[0], env)
-	if err != "" {
+	if err != nil {
 		t.Error(expr, "gives error:", err)
 		return
 	}
@@ -87,7 +96,7 @@ func evalExpectAsString(t *testing.T, expr string, expect string, env *Env) {
 
 	//TODO: fix this to do something more sensible than just eval the first one
 	x, err := Eval(sexps[0], env)
-	if err != "" {
+	if err != nil {
 		t.Error(expr, "gives error:", err)
 		return
 	}
@@ -118,11 +127,11 @@ func evalExpectError(t *testing.T, expr string, expect string, env *Env) {
 
 	//TODO: fix this to do something more sensible than just eval the first one
 	x, err := Eval(sexps[0], env)
-	if err == "" {
+	if err == nil {
 		t.Errorf("%s gives %v, want error: %s\n", expr, x, expect)
 		return
 	}
-	if err != expect {
+	if err.Error() != expect {
 		t.Errorf("%s gives error: %s, want error: %s\n", expr, err, expect)
 		return
 	}
@@ -194,8 +203,70 @@ func TestLetBinding(t *testing.T) {
 	evalExpectError(t, "(let ((x 2) '(y 3)) (+ x y))", "Binding #2 was literal; no binding may be literal.", env)
 	evalExpectError(t, "(let ((2 3)) (x))", "Binding #1 has a non-string, empty string, or string literal symbol.", env)
 
-	// Test recursive references within let environment
-	evalExpectInt(t, "(let ((let-fib (bring-me-back-something-good (n) (insofaras (< n 2) n (+ (let-fib (- n 1)) (let-fib (- n 2))))))) (let-fib 10))", 55, env)
+	// Test recursive references within letrec environment
+	evalExpectInt(t, "(letrec ((let-fib (bring-me-back-something-good (n) (insofaras (< n 2) n (+ (let-fib (- n 1)) (let-fib (- n 2))))))) (let-fib 10))", 55, env)
+}
+
+func TestLetForms(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	// let evaluates its bindings in the outer scope
+	evalExpectAsString(t, "(yknow x 1)", "", env)
+	evalExpectInt(t, "(let ((x 2) (y x)) y)", 1, env)
+	evalExpectError(t, "(let ((f (bring-me-back-something-good () (f)))) (f))", "'f' not found in scope chain.", env)
+	evalExpectError(t, "(let ((x 1) (x 2)) x)", "Binding #2 attempted to re-bind already bound symbol 'x'.", env)
+
+	// let* evaluates each binding in the scope of the ones before it
+	evalExpectInt(t, "(let* ((x 2) (y x)) y)", 2, env)
+	evalExpectInt(t, "(let* ((x 2) (x (+ x 1))) x)", 3, env)
+
+	// letrec and letrec* can define mutually recursive procedures
+	evalExpectBool(t, "(letrec ((ev? (bring-me-back-something-good (n) (insofaras (eq? n 0) #t (od? (- n 1))))) (od? (bring-me-back-something-good (n) (insofaras (eq? n 0) #f (ev? (- n 1)))))) (ev? 10))", true, env)
+	evalExpectInt(t, "(letrec* ((a 5) (b (+ a 1))) b)", 6, env)
+
+	// Named let loops, in constant stack space
+	evalExpectInt(t, "(let loop ((i 0) (acc 0)) (insofaras (eq? i 100000) acc (loop (+ i 1) (+ acc i))))", 4999950000, env)
+	evalExpectAsString(t, "(let loop ((i 3)) (insofaras (eq? i 0) '() (cons i (loop (- i 1)))))", "(3 2 1)", env)
+
+	// Named let and apply hand the procedure its arguments as they are, rather than evaluating them again
+	evalExpectAsString(t, "(let loop ((l (you-folks 'a (you-folks 1 2)))) l)", "(a (1 2))", env)
+	evalExpectAsString(t, "(crunch-crunch-crunch you-folks 'a (you-folks (you-folks 1 2)))", "(a (1 2))", env)
+	evalExpectError(t, "(crunch-crunch-crunch (bring-me-back-something-good (a) a) '(1 2))", "Too many arguments", env)
+
+	evalExpectAsString(t, "(reverse '(1 2 3))", "(3 2 1)", env)
+}
+
+func TestBodies(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	// define shorthand, with internal defines scoped to the body
+	evalExpectAsString(t, "(define (hypot-squared a b) (define a2 (* a a)) (define b2 (* b b)) (+ a2 b2))", "", env)
+	evalExpectInt(t, "(hypot-squared 3 4)", 25, env)
+	evalExpectError(t, "a2", "'a2' not found in scope chain.", env)
+	evalExpectAsString(t, "hypot-squared", "#<procedure:hypot-squared>", env)
+	evalExpectAsString(t, "(define (list-of . items) items)", "", env)
+	evalExpectAsString(t, "(list-of 1 2)", "(1 2)", env)
+
+	// Internal defines can be mutually recursive
+	evalExpectBool(t, "((bring-me-back-something-good (n) (define (ev? n) (insofaras (eq? n 0) #t (od? (- n 1)))) (define (od? n) (insofaras (eq? n 0) #f (ev? (- n 1)))) (ev? n)) 7)", false, env)
+
+	// Multi-expression bodies
+	evalExpectInt(t, "((bring-me-back-something-good (x) (set! x (* x 2)) (+ x 1)) 5)", 11, env)
+	evalExpectInt(t, "(let ((x 1)) (set! x 2) x)", 2, env)
+	evalExpectInt(t, "(let loop ((i 0)) (define next (+ i 1)) (insofaras (eq? next 5) next (loop next)))", 5, env)
+	evalExpectInt(t, "(cond (#f 1) (#t (define y 3) (* y y)))", 9, env)
+	evalExpectError(t, "y", "'y' not found in scope chain.", env)
+	evalExpectInt(t, "((case-lambda ((a) (define b 2) (+ a b))) 1)", 3, env)
+
+	// begin doesn't open a scope
+	evalExpectAsString(t, "(begin (define top 1) (define top2 2))", "", env)
+	evalExpectInt(t, "(+ top top2)", 3, env)
+
+	evalExpectError(t, "(bring-me-back-something-good (x))", "lambda needs at least one expression in its body.", env)
+	evalExpectError(t, "(define (f))", "define takes a symbol and a value.", env)
+	evalExpectError(t, "(cond (#t))", "Clause #1 needs a test and at least one expression.", env)
 }
 
 func TestCoolBuiltins(t *testing.T) {
@@ -249,16 +320,344 @@ func TestIsEmpty(t *testing.T) {
 	evalExpectError(t, "(empty? (you-folks) (you-folks))", "Invalid arguments. Expecting exactly 1 argument.", env)
 }
 
+func TestCallCC(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	// Escaping
+	evalExpectInt(t, "(+ 1 (call/cc (bring-me-back-something-good (k) (+ 10 (k 5)))))", 6, env)
+	evalExpectInt(t, "(+ 1 (call/cc (bring-me-back-something-good (k) 5)))", 6, env)
+	evalExpectInt(t, "(call-with-current-continuation (bring-me-back-something-good (k) (* 2 (k 3))))", 3, env)
+
+	// Re-entry
+	evalExpectInt(t, "(let ((r (call/cc (bring-me-back-something-good (k) (you-folks k))))) (insofaras (pair? r) ((one-less-car r) 42) r))", 42, env)
+
+	// Re-entry from a later evaluation resumes the captured stack
+	evalExpectAsString(t, "(yknow k (call/cc (bring-me-back-something-good (c) c)))", "", env)
+	evalExpectAsString(t, "(k 5)", "", env)
+	evalExpectInt(t, "k", 5, env)
+
+	evalExpectError(t, "(call/cc 5)", "Argument to call/cc must be a procedure.", env)
+	evalExpectError(t, "(call/cc)", "call/cc takes exactly one argument.", env)
+}
+
+func TestStrings(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, `"hello world"`, `"hello world"`, env)
+	evalExpectAsString(t, `"tab\there \"quoted\" \\ \x41;"`, `"tab\there \"quoted\" \\ A"`, env)
+	evalExpectAsString(t, `"\a\b\0\x1b;\x7f;"`, `"\a\b\0\x1b;\x7f;"`, env)
+
+	// Printed strings read back as the same string
+	for _, str := range []PTString{"bell\a back\b nul\x00", "esc\x1b del\x7f next\u0085", "quote\" slash\\ line\n\r\t", "héllo ☃"} {
+		printed := str.String()
+		sexps, err := ParseLine(printed)
+		if err != nil || len(sexps) != 1 || sexps[0] != str {
+			t.Errorf("%q prints as %s, which reads back as %v, %v\n", string(str), printed, sexps, err)
+		}
+	}
+	evalExpectBool(t, `(string? "hi")`, true, env)
+	evalExpectBool(t, `(string? 'hi)`, false, env)
+	evalExpectBool(t, `(eq? "abc" (string-append "a" "bc"))`, true, env)
+
+	evalExpectAsString(t, `(string-append "golf" " " "talk")`, `"golf talk"`, env)
+	evalExpectInt(t, `(string-length "héllo")`, 5, env)
+	evalExpectAsString(t, `(substring "héllo" 1 3)`, `"él"`, env)
+	evalExpectAsString(t, `(substring "hello" 2)`, `"llo"`, env)
+	evalExpectAsString(t, `(string-split "  a b\tc ")`, `("a" "b" "c")`, env)
+	evalExpectAsString(t, `(string-split "a,b,,c" ",")`, `("a" "b" "" "c")`, env)
+	evalExpectAsString(t, `(string-upcase "Hello")`, `"HELLO"`, env)
+	evalExpectAsString(t, `(string-downcase "Hello")`, `"hello"`, env)
+	evalExpectBool(t, `(string<? "abc" "abd")`, true, env)
+	evalExpectAsString(t, `(string->symbol "foo")`, `'foo`, env)
+	evalExpectAsString(t, `(symbol->string 'foo)`, `"foo"`, env)
+	evalExpectInt(t, `(string->number "42")`, 42, env)
+	evalExpectBool(t, `(string->number "forty-two")`, false, env)
+	evalExpectAsString(t, `(number->string 2.5)`, `"2.5"`, env)
+
+	evalExpectError(t, `(substring "hello" 3 10)`, "Substring indices 3 and 10 out of range for string of length 5.", env)
+	evalExpectError(t, `(string-append "a" 'b)`, "Invalid types to append. Must all be string.", env)
+
+	if _, err := ParseLine(`(string-length "oops)`); err == nil {
+		t.Error("unterminated string literal parses without error")
+	}
+	if _, err := ParseLine(`"\q"`); err == nil {
+		t.Error("unknown escape sequence parses without error")
+	}
+}
+
+func TestDottedPairs(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "'(a . b)", "(a . b)", env)
+	evalExpectAsString(t, "'(1 2 . 3)", "(1 2 . 3)", env)
+	evalExpectAsString(t, "'(1 . (2 3))", "(1 2 3)", env)
+	evalExpectAsString(t, "(cons 1 2)", "(1 . 2)", env)
+	evalExpectAsString(t, "(cons 1 (cons 2 3))", "(1 2 . 3)", env)
+	evalExpectAsString(t, "(cons 1 (list 2 3))", "(1 2 3)", env)
+	evalExpectAsString(t, "(come-from-behind '(a . b))", "'b", env)
+	evalExpectBool(t, "(pair? (cons 1 2))", true, env)
+
+	// Association lists
+	evalExpectAsString(t, "(yknow alist (list (cons 'x 1) (cons 'y 2)))", "", env)
+	evalExpectInt(t, "(come-from-behind (one-less-car (come-from-behind alist)))", 2, env)
+	evalExpectBool(t, "(eq? (one-less-car (one-less-car alist)) 'x)", true, env)
+
+	improper, _ := ParseLine("'(1 2 . 3)")
+	lst, _ := improper[0].(*SexpPair)
+	if _, err := lst.Len(); err == nil {
+		t.Error("Len of an improper list gives no error")
+	}
+	if _, err := ToSlice(lst); err == nil {
+		t.Error("ToSlice of an improper list gives no error")
+	}
+
+	for _, bad := range []string{"'(. 1)", "'(1 .)", "'(1 . 2 3)", "."} {
+		if _, err := ParseLine(bad); err == nil {
+			t.Error(bad, "parses without error")
+		}
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "(in-fact 25)", "15511210043330985984000000", env)
+	evalExpectAsString(t, "(pow 2 100)", "1267650600228229401496703205376", env)
+	evalExpectAsString(t, "(+ 9223372036854775807 1)", "9223372036854775808", env)
+	evalExpectAsString(t, "(- -9223372036854775808 1)", "-9223372036854775809", env)
+	evalExpectAsString(t, "(- -9223372036854775808)", "9223372036854775808", env)
+	evalExpectAsString(t, "(* 4294967296 4294967296)", "18446744073709551616", env)
+	evalExpectAsString(t, "123456789012345678901234567890", "123456789012345678901234567890", env)
+
+	// Results small enough for an int are demoted again
+	evalExpectInt(t, "(- (+ 9223372036854775807 10) 20)", 9223372036854775797, env)
+	evalExpectInt(t, "(/ (in-fact 25) (in-fact 23))", 600, env)
+	evalExpectInt(t, "(% (pow 2 100) 7)", 2, env)
+	evalExpectInt(t, "(sqrt (pow 2 100))", 1125899906842624, env)
+
+	evalExpectBool(t, "(eq? (in-fact 25) (in-fact 25))", true, env)
+	evalExpectBool(t, "(< (in-fact 25) (in-fact 26))", true, env)
+	evalExpectBool(t, "(< (in-fact 25) 1.5)", false, env)
+	evalExpectAsString(t, "(string->number \"100000000000000000000\")", "100000000000000000000", env)
+}
+
+func TestRationals(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "(/ 1 3)", "1/3", env)
+	evalExpectAsString(t, "(/ 4 6)", "2/3", env)
+	evalExpectInt(t, "(/ 6 3)", 2, env)
+	evalExpectAsString(t, "(/ 1 2.0)", "0.5", env)
+	evalExpectAsString(t, "(/ 7 -2)", "-7/2", env)
+	evalExpectAsString(t, "2/4", "1/2", env)
+	evalExpectInt(t, "9/3", 3, env)
+
+	evalExpectAsString(t, "(+ 1/3 1/6)", "1/2", env)
+	evalExpectInt(t, "(+ 1/3 2/3)", 1, env)
+	evalExpectAsString(t, "(- 1/2 1)", "-1/2", env)
+	evalExpectAsString(t, "(* 2/3 3/4)", "1/2", env)
+	evalExpectAsString(t, "(+ 1/2 0.25)", "0.75", env)
+	evalExpectAsString(t, "(/ (in-fact 25) (in-fact 27))", "1/702", env)
+
+	evalExpectInt(t, "(numerator 6/4)", 3, env)
+	evalExpectInt(t, "(denominator 6/4)", 2, env)
+	evalExpectInt(t, "(denominator 5)", 1, env)
+	evalExpectAsString(t, "(exact->inexact 1/4)", "0.25", env)
+	evalExpectAsString(t, "(inexact->exact 0.75)", "3/4", env)
+	evalExpectInt(t, "(quotient 7 2)", 3, env)
+
+	evalExpectBool(t, "(< 1/3 0.34)", true, env)
+	evalExpectBool(t, "(< 1/3 1/4)", false, env)
+	evalExpectBool(t, "(eq? 1/2 (/ 2 4))", true, env)
+	evalExpectBool(t, "(eq? 1/2 0.5)", false, env)
+	evalExpectInt(t, "(<==> 1/3 1/4)", 1, env)
+	evalExpectInt(t, "(<==> 2/4 1/2)", 0, env)
+
+	evalExpectError(t, "(/ 1/2 0)", "Division by zero is currently unsupported.", env)
+	evalExpectError(t, "(% 1/2 2)", "Invalid types to divide. Must be int and int.", env)
+
+	// Odd-length lists still split evenly
+	evalExpectAsString(t, "(merge-sort '(3 1 2))", "(1 2 3)", env)
+}
+
+func TestSet(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "(yknow x 1)", "", env)
+	evalExpectAsString(t, "(set! x (+ x 1))", "", env)
+	evalExpectInt(t, "x", 2, env)
+
+	// set! mutates the nearest binding, not the global one
+	evalExpectInt(t, "(let ((x 10)) (begin (set! x 20) x))", 20, env)
+	evalExpectInt(t, "x", 2, env)
+
+	// Closures capture their defining scope, and can update it
+	evalExpectAsString(t, "(yknow make-counter (bring-me-back-something-good () (let ((n 0)) (bring-me-back-something-good () (begin (set! n (+ n 1)) n)))))", "", env)
+	evalExpectAsString(t, "(yknow counter (make-counter))", "", env)
+	evalExpectAsString(t, "(yknow other-counter (make-counter))", "", env)
+	evalExpectInt(t, "(counter)", 1, env)
+	evalExpectInt(t, "(counter)", 2, env)
+	evalExpectInt(t, "(other-counter)", 1, env)
+	evalExpectInt(t, "(counter)", 3, env)
+
+	evalExpectError(t, "(set! not-bound-anywhere 5)", "'not-bound-anywhere' not found in scope chain.", env)
+	evalExpectError(t, "(set! 5 5)", "Symbol given to set! wasn't a symbol.", env)
+	evalExpectError(t, "(set! x)", "set! takes two arguments: a symbol and a value.", env)
+}
+
+func TestMacros(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "(define-syntax swap! (syntax-rules () ((_ a b) (let ((tmp a)) (begin (set! a b) (set! b tmp))))))", "", env)
+	evalExpectAsString(t, "(yknow x 1)", "", env)
+	evalExpectAsString(t, "(yknow y 2)", "", env)
+	evalExpectAsString(t, "(swap! x y)", "", env)
+	evalExpectAsString(t, "(you-folks x y)", "(2 1)", env)
+
+	// Hygiene: the template's tmp doesn't capture the user's
+	evalExpectAsString(t, "(yknow tmp 3)", "", env)
+	evalExpectAsString(t, "(swap! tmp x)", "", env)
+	evalExpectAsString(t, "(you-folks tmp x)", "(2 3)", env)
+
+	// Hygiene: the template's if means the if in scope where the macro was defined
+	evalExpectAsString(t, "(define-syntax my-or (syntax-rules () ((_) #f) ((_ e) e) ((_ e r ...) (let ((t e)) (if t t (my-or r ...))))))", "", env)
+	evalExpectInt(t, "(my-or #f #f 7)", 7, env)
+	evalExpectInt(t, "(let ((t 5)) (my-or #f t))", 5, env)
+	evalExpectInt(t, "(let ((if (bring-me-back-something-good (a b c) 0))) (my-or #f 9))", 9, env)
+	evalExpectBool(t, "(my-or)", false, env)
+
+	// Literals, nested ellipses and quoting
+	evalExpectAsString(t, "(define-syntax my-cond (syntax-rules (else) ((_ (else e)) e) ((_ (c e) rest ...) (if c e (my-cond rest ...)))))", "", env)
+	evalExpectInt(t, "(my-cond (#f 1) ((eq? 1 2) 2) (else 3))", 3, env)
+	evalExpectAsString(t, "(define-syntax flatten (syntax-rules () ((_ (a ...) ...) '(a ... ...))))", "", env)
+	evalExpectAsString(t, "(flatten (1 2) () (3))", "(1 2 3)", env)
+	evalExpectAsString(t, "(define-syntax quote-all (syntax-rules () ((_ a ...) (you-folks (quote a) ...))))", "", env)
+	evalExpectAsString(t, "(quote-all foo bar)", "(foo bar)", env)
+	evalExpectAsString(t, "(define-syntax tail (syntax-rules () ((_ a ... z) 'z)))", "", env)
+	evalExpectAsString(t, "(tail 1 2 3)", "3", env)
+
+	// Library macros
+	evalExpectAsString(t, "(yknow n 0)", "", env)
+	evalExpectAsString(t, "(while (< n 10) (set! n (+ n 1)))", "#f", env)
+	evalExpectInt(t, "n", 10, env)
+	evalExpectInt(t, "(when (< n 20) (set! n 0) 4)", 4, env)
+	evalExpectInt(t, "(unless (> n 20) 1)", 1, env)
+
+	// let-syntax and letrec-syntax are scoped to their bodies
+	evalExpectInt(t, "(let-syntax ((double (syntax-rules () ((_ e) (* 2 e))))) (double 21))", 42, env)
+	evalExpectError(t, "(double 21)", "'double' not found in scope chain.", env)
+	evalExpectInt(t, "(letrec-syntax ((count-args (syntax-rules () ((_) 0) ((_ a b ...) (+ 1 (count-args b ...)))))) (count-args x y z))", 3, env)
+
+	// Macros defined in a body see the variables around them, and not the ones where they're used
+	evalExpectInt(t, "(let ((k 5)) (let-syntax ((add-k (syntax-rules () ((_ e) (+ k e))))) (let ((k 100)) (add-k 1))))", 6, env)
+	evalExpectAsString(t, "(yknow add-n (bring-me-back-something-good (n) (begin (define-syntax add (syntax-rules () ((_ e) (+ n e)))) (add 1))))", "", env)
+	evalExpectInt(t, "(add-n 10)", 11, env)
+	evalExpectError(t, "(add 1)", "'add' not found in scope chain.", env)
+
+	// Uses are expanded once, before the code they're in runs
+	evalExpectAsString(t, "(define-syntax one (syntax-rules () ((_) 1)))", "", env)
+	evalExpectAsString(t, "(yknow get-one (bring-me-back-something-good () (one)))", "", env)
+	evalExpectAsString(t, "(define-syntax one (syntax-rules () ((_) 2)))", "", env)
+	evalExpectInt(t, "(get-one)", 1, env)
+
+	// Macros aren't values
+	evalExpectError(t, "(yknow alias swap!)", "Bad syntax: macro 'swap!' can't be used as a value.", env)
+	evalExpectError(t, "(you-folks when)", "Bad syntax: macro 'when' can't be used as a value.", env)
+	evalExpectInt(t, "(let ((when 3)) when)", 3, env)
+
+	evalExpectError(t, "(swap! x)", "Bad syntax: no pattern of 'swap!' matches (swap! x).", env)
+	evalExpectError(t, "(define-syntax nope 5)", "define-syntax needs a syntax transformer, such as syntax-rules.", env)
+}
+
+func TestQuasiquote(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "(yknow x 5)", "", env)
+	evalExpectAsString(t, "(yknow lst '(1 2 3))", "", env)
+
+	evalExpectAsString(t, "`(a b c)", "(a b c)", env)
+	evalExpectAsString(t, "`(x ,x)", "(x 5)", env)
+	evalExpectAsString(t, "`(1 ,(+ 1 1) ,@lst 4)", "(1 2 1 2 3 4)", env)
+	evalExpectAsString(t, "`(,@lst)", "(1 2 3)", env)
+	evalExpectAsString(t, "`(0 . ,x)", "(0 . 5)", env)
+	evalExpectAsString(t, "`((nested ,x) ,@(map (bring-me-back-something-good (n) (* n n)) lst))", "((nested 5) 1 4 9)", env)
+	evalExpectAsString(t, "(quasiquote (a (unquote x)))", "(a 5)", env)
+	evalExpectAsString(t, "`,x", "5", env)
+	evalExpectAsString(t, "`sym", "'sym", env)
+
+	// Nested quasiquotes only evaluate the outermost level
+	evalExpectAsString(t, "`(a `(b ,(c ,x)))", "(a (quasiquote (b (unquote (c 5)))))", env)
+
+	// Splicing copies, so the original list is untouched
+	evalExpectAsString(t, "`(,@lst 4)", "(1 2 3 4)", env)
+	evalExpectAsString(t, "lst", "(1 2 3)", env)
+
+	// In quoted data, the abbreviations are just data
+	evalExpectAsString(t, "'(a ,b)", "(a (unquote b))", env)
+
+	evalExpectError(t, "`(,@x)", "unquote-splicing: value to splice is not a list.", env)
+	evalExpectError(t, "(unquote x)", "unquote is only allowed within quasiquote.", env)
+}
+
+func TestVariadicLambdas(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "((bring-me-back-something-good args args) 1 2 3)", "(1 2 3)", env)
+	evalExpectAsString(t, "((bring-me-back-something-good args args))", "()", env)
+	evalExpectAsString(t, "((bring-me-back-something-good (a . rest) (you-folks a rest)) 1 2 3)", "(1 (2 3))", env)
+	evalExpectAsString(t, "((bring-me-back-something-good (a #!rest rest) rest) 1)", "()", env)
+	evalExpectError(t, "((bring-me-back-something-good (a b . rest) a) 1)", "Too few arguments", env)
+
+	// Optional parameters
+	evalExpectAsString(t, "(yknow opt (bring-me-back-something-good (a #!optional b (c (+ a 10))) (you-folks a b c)))", "", env)
+	evalExpectAsString(t, "(opt 1)", "(1 #f 11)", env)
+	evalExpectAsString(t, "(opt 1 2)", "(1 2 11)", env)
+	evalExpectAsString(t, "(opt 1 2 3)", "(1 2 3)", env)
+	evalExpectError(t, "(opt 1 2 3 4)", "Too many arguments", env)
+
+	// case-lambda
+	evalExpectAsString(t, "(yknow area (case-lambda ((r) (* 3 r r)) ((w h) (* w h)) ((w h . more) (you-folks w h more))))", "", env)
+	evalExpectInt(t, "(area 2)", 12, env)
+	evalExpectInt(t, "(area 2 5)", 10, env)
+	evalExpectAsString(t, "(area 1 2 3 4)", "(1 2 (3 4))", env)
+	evalExpectError(t, "(area)", "No case-lambda clause accepts 0 arguments.", env)
+	evalExpectAsString(t, "area", "#<procedure:area>", env)
+
+	// Variadic helpers in golftalk itself
+	evalExpectAsString(t, "(yknow add-all (bring-me-back-something-good nums (foldl + 0 nums)))", "", env)
+	evalExpectInt(t, "(add-all 1 2 3 4)", 10, env)
+	evalExpectAsString(t, "(append '(1 2) '(3) '() '(4 5))", "(1 2 3 4 5)", env)
+	evalExpectAsString(t, "(append)", "()", env)
+
+	// apply spreads extra arguments, and can run the same code repeatedly
+	evalExpectAsString(t, "(yknow sum-list (bring-me-back-something-good (lst) (apply + lst)))", "", env)
+	evalExpectInt(t, "(sum-list '(1 2 3))", 6, env)
+	evalExpectInt(t, "(sum-list '(4 5))", 9, env)
+	evalExpectInt(t, "(apply + 1 2 '(3 4))", 10, env)
+
+	evalExpectError(t, "(bring-me-back-something-good (a #!optional b #!optional c) a)", "#!optional must come before any optional or rest parameters.", env)
+	evalExpectError(t, "(bring-me-back-something-good (a 5) a)", "Symbol list to bind within lambda contained a non-symbol.", env)
+}
+
 func BenchmarkFib(b *testing.B) {
 	env := NewEnv()
 	InitGlobalEnv(env)
 
-	expr := &SexpPair{Symbol("fib"), &SexpPair{PTInt(25), EmptyList, false}, false}
+	expr := &SexpPair{Symbol("fib"), &SexpPair{PTInt(25), EmptyList, false, nil, nil}, false, nil, nil}
 
 	b.ResetTimer()
 	for t := 0; t < b.N; t++ {
 		result, err := Eval(expr, env)
-		if err != "" {
+		if err != nil {
 			b.Error("fib returned error:", err)
 			continue
 		}
@@ -273,3 +672,757 @@ func BenchmarkFib(b *testing.B) {
 		}
 	}
 }
+
+func TestEvalErrors(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalError := func(expr string) *EvalError {
+		sexps, parseErr := ParseLine(expr)
+		if parseErr != nil {
+			t.Fatal(expr, "parsing gives error:", parseErr.Error())
+		}
+		_, err := Eval(sexps[0], env)
+		var evalErr *EvalError
+		if !errors.As(err, &evalErr) {
+			t.Fatalf("%s gives error %v, want an *EvalError\n", expr, err)
+		}
+		return evalErr
+	}
+
+	err := evalError("(+ 1 (car 5))")
+	if err.Kind != TypeError {
+		t.Errorf("(car 5) gives a %s, want a %s\n", err.Kind, TypeError)
+	}
+	if SexpToString(err.Expr) != "(car 5)" {
+		t.Errorf("(car 5) blames %s\n", SexpToString(err.Expr))
+	}
+	if len(err.Trace) != 2 || err.Trace[0].Name != "car" || err.Trace[1].Name != "+" {
+		t.Errorf("(+ 1 (car 5)) gives trace %v, want [car +]\n", err.Trace)
+	}
+
+	err = evalError("(+ 1 not-bound)")
+	if err.Kind != UnboundError || err.Expr != Symbol("not-bound") {
+		t.Errorf("unbound symbol gives a %s blaming %s\n", err.Kind, SexpToString(err.Expr))
+	}
+
+	evalExpectAsString(t, "(yknow (two-args a b) a)", "", env)
+	err = evalError("(let ((x 1)) (two-args x))")
+	if err.Kind != ArityError || len(err.Trace) != 1 || err.Trace[0].Name != "two-args" {
+		t.Errorf("call with too few arguments gives a %s with trace %v\n", err.Kind, err.Trace)
+	}
+
+	if err = evalError("(set! 1 2)"); err.Kind != SyntaxError {
+		t.Errorf("malformed set! gives a %s, want a %s\n", err.Kind, SyntaxError)
+	}
+	if err = evalError("(/ 1 0)"); err.Kind != ValueError {
+		t.Errorf("division by zero gives a %s, want a %s\n", err.Kind, ValueError)
+	}
+	for _, expr := range []string{"(car '())", "(cdr '())"} {
+		if err = evalError(expr); err.Kind != ValueError {
+			t.Errorf("%s gives a %s, want a %s\n", expr, err.Kind, ValueError)
+		}
+	}
+
+	// Panics in the interpreter are errors like any other
+	env.Dict["go-boom"] = &GoProc{"go-boom", func(args ...Expression) (Expression, error) {
+		panic("boom")
+	}}
+	err = evalError("(+ 1 (go-boom))")
+	if err.Kind != InternalError || err.Message != "boom" || SexpToString(err.Expr) != "(go-boom)" {
+		t.Errorf("panic gives a %s blaming %s: %s\n", err.Kind, SexpToString(err.Expr), err.Message)
+	}
+	evalExpectInt(t, "(+ 1 2)", 3, env)
+}
+
+func TestSourcePositions(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	scanner := NewScanner(strings.NewReader("(define (f x)\n  (+ x\n     (car x)))\n(f 5)\n(f\n  not-bound)"))
+	scanner.File = "test.scm"
+	sexps, parseErr := Parse(scanner)
+	if parseErr != nil {
+		t.Fatal("parsing gives error:", parseErr.Error())
+	}
+	if _, err := Eval(sexps[0], env); err != nil {
+		t.Fatal("defining f gives error:", err)
+	}
+
+	_, err := Eval(sexps[1], env)
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) {
+		t.Fatalf("(f 5) gives error %v, want an *EvalError\n", err)
+	}
+	if evalErr.Pos == nil || evalErr.Pos.String() != "test.scm:3:6" {
+		t.Errorf("(f 5) gives an error at %v, want test.scm:3:6\n", evalErr.Pos)
+	}
+	// f's frame was replaced by the tail call to +
+	want := []string{"car at test.scm:3:6", "+ at test.scm:2:3"}
+	if len(evalErr.Trace) != len(want) {
+		t.Fatalf("(f 5) gives trace %v, want %v\n", evalErr.Trace, want)
+	}
+	for i := range want {
+		if evalErr.Trace[i].String() != want[i] {
+			t.Errorf("(f 5) gives trace %v, want %v\n", evalErr.Trace, want)
+		}
+	}
+
+	_, err = Eval(sexps[2], env)
+	if !errors.As(err, &evalErr) || evalErr.Pos == nil || evalErr.Pos.String() != "test.scm:6:3" {
+		t.Errorf("unbound symbol gives error %v, want one at test.scm:6:3\n", err)
+	}
+
+	// The head of a call is where it was read, not where the call opened
+	for expr, expect := range map[string]string{
+		"(nope 1)":          "1:2",
+		"(+ 1\n  (- nope))": "2:6",
+	} {
+		sexps, _ := ParseLine(expr)
+		_, err = Eval(sexps[0], env)
+		if !errors.As(err, &evalErr) || evalErr.Pos == nil || evalErr.Pos.String() != expect {
+			t.Errorf("%s gives error %v, want one at %s\n", expr, err, expect)
+		}
+	}
+
+	// Parse errors give the line and column they were found at
+	for expr, expect := range map[string]string{
+		"(+ 1\n   2))":     "parse error: 2:6: unexpected \")\"",
+		"(1 .\n )":         "parse error: 2:2: expected an element after \".\"",
+		"(list\n  \"oops)": "parse error: 2:3: unterminated string literal",
+		"\"ok\\q\"":        "parse error: 1:4: unknown escape sequence \"\\q\" in string literal",
+	} {
+		if _, err := ParseLine(expr); err == nil || err.Error() != expect {
+			t.Errorf("parsing %q gives error %v, want %s\n", expr, err, expect)
+		}
+	}
+}
+
+func TestDebugger(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+	evalExpectAsString(t, "(define (sq x) (* x x))", "", env)
+
+	debug := func(expr string, commands string) (string, Expression, error) {
+		var out strings.Builder
+		debugger := NewDebugger(bufio.NewReader(strings.NewReader(commands)), &out)
+		debugger.Breakpoints["sq"] = true
+		sexps, parseErr := ParseLine(expr)
+		if parseErr != nil {
+			t.Fatal(expr, "parsing gives error:", parseErr.Error())
+		}
+		result, err := debugger.Eval(sexps[0], env)
+		return out.String(), result, err
+	}
+
+	// Stop at the breakpoint, look around, and step into the body
+	out, result, err := debug("(+ 1 (sq 3))", "bt\nframe 1\nstep\nstep\np (+ x 10)\ncontinue\n")
+	if err != nil || result != PTInt(10) {
+		t.Errorf("debugging (+ 1 (sq 3)) gives %v, %v, want 10\n", result, err)
+	}
+	for _, expect := range []string{
+		"Breakpoint: sq\n-> (sq 3) at 1:6\n",
+		"#0 (sq 3) at 1:6\n#1 + at 1:1\n",
+		"  call: (+ 1 (sq 3))\n  running: #<procedure:+>\n  evaluated: (1)\n",
+		"-> (* x x) at 1:16\n",
+		"debug> 13\n",
+	} {
+		if !strings.Contains(out, expect) {
+			t.Errorf("debugging (+ 1 (sq 3)) gives output\n%s\nwhich is missing\n%s\n", out, expect)
+		}
+	}
+
+	// next steps over the inner call, and quitting abandons the evaluation
+	out, _, err = debug("(sq (sq 2))", "delete sq\nnext\np x\nquit\n")
+	if err == nil || err.Error() != "Evaluation abandoned in the debugger." {
+		t.Errorf("quitting the debugger gives error %v\n", err)
+	}
+	if strings.Count(out, "Breakpoint") != 1 || !strings.Contains(out, "-> (* x x) at 1:16\ndebug> 4\n") {
+		t.Errorf("next from (sq (sq 2)) gives output\n%s\n", out)
+	}
+}
+
+func TestExceptions(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	// guard catches whatever is raised in its body
+	evalExpectInt(t, "(guard (e (#t (* e 2))) (+ 1 (raise 21)))", 42, env)
+	evalExpectAsString(t, `(guard (e ((string? e) e)) (raise "oops"))`, `"oops"`, env)
+	evalExpectAsString(t, `(guard (e ((error-object? e) (error-object-message e))) (error "bad thing" 1 2))`, `"bad thing"`, env)
+	evalExpectAsString(t, `(guard (e ((error-object? e) (error-object-irritants e))) (error "bad thing" 1 'x))`, "(1 x)", env)
+	evalExpectInt(t, "(guard (e (#f 0)) 5)", 5, env)
+
+	// Built-in errors are raised as error objects
+	evalExpectAsString(t, `(guard (e ((error-object? e) (error-object-message e))) (car 5))`, `"Invalid type. Can only take the car of a list."`, env)
+	evalExpectAsString(t, `(guard (e ((error-object? e) (error-object-message e))) (+ 1 not-bound))`, `"'not-bound' not found in scope chain."`, env)
+
+	// A guard with no passing clause raises the object again
+	evalExpectInt(t, "(guard (e (#t e)) (guard (e ((string? e) 0)) (raise 7)))", 7, env)
+	evalExpectError(t, "(guard (e ((string? e) 0)) (car 5))", "Invalid type. Can only take the car of a list.", env)
+	evalExpectError(t, `(error "Something broke:" 42)`, "Something broke: 42", env)
+
+	// A handler returns to raise-continuable, but may not return to raise
+	evalExpectInt(t, "(with-exception-handler (lambda (e) (* e 10)) (lambda () (+ 1 (raise-continuable 4))))", 41, env)
+	evalExpectError(t, "(with-exception-handler (lambda (e) 0) (lambda () (+ 1 (raise 4))))", "Exception handler returned from a non-continuable raise.", env)
+	evalExpectInt(t, "(call/cc (lambda (k) (with-exception-handler (lambda (e) (k (* e 3))) (lambda () (+ 1 (raise 4))))))", 12, env)
+
+	// Handlers run with the outer handler in effect
+	evalExpectInt(t, `
+		(with-exception-handler
+			(lambda (e) (+ e 100))
+			(lambda ()
+				(with-exception-handler
+					(lambda (e) (raise-continuable (+ e 10)))
+					(lambda () (+ 1 (raise-continuable 1))))))`, 112, env)
+	evalExpectAsString(t, "(guard (e ((error-object? e) (error-object-message e))) (with-exception-handler (lambda (e) 0) (lambda () (raise 3))))", `"Exception handler returned from a non-continuable raise."`, env)
+}
+
+func TestDynamicWind(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "(define trail '())", "", env)
+	evalExpectAsString(t, "(define (note x) (set! trail (cons x trail)))", "", env)
+	evalExpectAsString(t, "(define (noting x thunk) (dynamic-wind (lambda () (note (list 'in x))) thunk (lambda () (note (list 'out x)))))", "", env)
+
+	evalExpectInt(t, "(noting 1 (lambda () (note 'body) 5))", 5, env)
+	evalExpectAsString(t, "trail", "((out 1) body (in 1))", env)
+
+	// Escaping runs the after thunks, innermost first
+	evalExpectAsString(t, "(set! trail '())", "", env)
+	evalExpectInt(t, "(call/cc (lambda (k) (noting 1 (lambda () (noting 2 (lambda () (k 7) (note 'never)))))))", 7, env)
+	evalExpectAsString(t, "trail", "((out 1) (out 2) (in 2) (in 1))", env)
+
+	// So do errors, whether they're caught or not
+	evalExpectAsString(t, "(set! trail '())", "", env)
+	evalExpectAsString(t, "(guard (e (#t (note 'caught))) (noting 1 (lambda () (car 5))))", "", env)
+	evalExpectAsString(t, "trail", "(caught (out 1) (in 1))", env)
+	evalExpectAsString(t, "(set! trail '())", "", env)
+	evalExpectError(t, "(noting 1 (lambda () (car 5)))", "Invalid type. Can only take the car of a list.", env)
+	evalExpectAsString(t, "trail", "((out 1) (in 1))", env)
+
+	// Re-entering runs the before thunks, outermost first
+	evalExpectAsString(t, "(define again #f)", "", env)
+	evalExpectAsString(t, "(noting 1 (lambda () (noting 2 (lambda () (call/cc (lambda (k) (set! again k)))))))", "", env)
+	evalExpectAsString(t, "(set! trail '())", "", env)
+	evalExpectInt(t, "(again 3)", 3, env)
+	evalExpectAsString(t, "trail", "((out 1) (out 2) (in 2) (in 1))", env)
+
+	// A jump between siblings leaves one and enters the other, but stays in their shared parent
+	evalExpectAsString(t, "(set! trail '())", "", env)
+	evalExpectAsString(t, "(define n 0)", "", env)
+	evalExpectInt(t, `
+		(noting 1 (lambda ()
+			(define k #f)
+			(call/cc (lambda (c) (set! k c)))
+			(set! n (+ n 1))
+			(noting 2 (lambda () (if (< n 2) (k #f) n)))))`, 2, env)
+	evalExpectAsString(t, "trail", "((out 1) (out 2) (in 2) (out 2) (in 2) (in 1))", env)
+
+	// Cleanups
+	evalExpectAsString(t, "(set! trail '())", "", env)
+	evalExpectAsString(t, "(guard (e (#t trail)) (unwind-protect (raise 'oops) (note 'cleaned) (note 'up)))", "(up cleaned)", env)
+	evalExpectInt(t, "(with-cleanup (lambda () 3) (lambda () (note 'done)))", 3, env)
+	evalExpectBool(t, "(eq? (car trail) 'done)", true, env)
+}
+
+func TestValues(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectInt(t, "(values 5)", 5, env)
+	evalExpectAsString(t, "(values 1 2 3)", "1 2 3", env)
+	evalExpectInt(t, "(call-with-values (lambda () (values 1 2)) +)", 3, env)
+	evalExpectInt(t, "(call-with-values (lambda () 4) (lambda (x) (* x x)))", 16, env)
+	evalExpectAsString(t, "(call-with-values (lambda () (values)) list)", "()", env)
+	evalExpectAsString(t, "(call-with-values (lambda () (values 'a '(b c))) list)", "(a (b c))", env)
+	evalExpectError(t, "(call-with-values (lambda () (values 1 2)) (lambda (x) x))", "Too many arguments", env)
+
+	evalExpectInt(t, "(let-values (((a b) (values 1 2)) ((c) (values 3))) (+ a b c))", 6, env)
+	evalExpectAsString(t, "(let-values (((a . rest) (values 1 2 3)) (all (values 4 5))) (list a rest all))", "(1 (2 3) (4 5))", env)
+	evalExpectInt(t, "(let ((a 10)) (let-values (((a) (values 1)) ((b) (values a))) b))", 10, env)
+	evalExpectError(t, "(let-values (((a b) (values 1))) a)", "Binding #1 of let-values can't take 1 values.", env)
+	evalExpectInt(t, "(receive (q . r) (values 7 8 9) (+ q (car r)))", 15, env)
+
+	// Multiple values are compared like vectors
+	evalExpectBool(t, "(eq? (values 1 2) (values 1 2))", false, env)
+	evalExpectBool(t, "(let ((v (values 1 2))) (eq? v v))", true, env)
+	evalExpectBool(t, "(equal? (values 1 2) (values 1 2))", true, env)
+	evalExpectBool(t, "(equal? (values 1 2) (values 1 3))", false, env)
+	evalExpectBool(t, "(equal? (values 1 2) '(1 2))", false, env)
+	evalExpectBool(t, "(eq? if if)", true, env)
+	evalExpectBool(t, "(eq? if quote)", false, env)
+
+	sexps, _ := ParseLine("(values 1 \"two\")")
+	result, err := Eval(sexps[0], env)
+	if err != nil {
+		t.Fatal("(values 1 \"two\") gives error:", err)
+	}
+	if vals := Values(result); len(vals) != 2 || vals[0] != PTInt(1) || vals[1] != PTString("two") {
+		t.Errorf("(values 1 \"two\") unpacks to %v\n", vals)
+	}
+}
+
+func TestVectors(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "#(1 2 3)", "#(1 2 3)", env)
+	evalExpectAsString(t, "#(a \"b\" (c d) #(e))", "#(a \"b\" (c d) #(e))", env)
+	evalExpectAsString(t, "'(1 #(2 3))", "(1 #(2 3))", env)
+	evalExpectAsString(t, "(vector 1 (+ 1 1))", "#(1 2)", env)
+	evalExpectAsString(t, "(make-vector 3 'x)", "#(x x x)", env)
+	evalExpectBool(t, "(vector? #())", true, env)
+	evalExpectBool(t, "(vector? '())", false, env)
+
+	evalExpectAsString(t, "(define v (make-vector 3 0))", "", env)
+	evalExpectInt(t, "(vector-length v)", 3, env)
+	evalExpectAsString(t, "(vector-set! v 1 'mid)", "", env)
+	evalExpectAsString(t, "(vector-ref v 1)", "'mid", env)
+	evalExpectAsString(t, "v", "#(0 mid 0)", env)
+	evalExpectError(t, "(vector-ref v 3)", "Vector index 3 out of range for vector of length 3.", env)
+	evalExpectError(t, "(vector-ref '(1 2) 0)", "Invalid type. Must be a vector.", env)
+	evalExpectAsString(t, "(vector-fill! v 7)", "", env)
+	evalExpectAsString(t, "v", "#(7 7 7)", env)
+
+	evalExpectAsString(t, "(vector->list #(1 2 3))", "(1 2 3)", env)
+	evalExpectAsString(t, "(list->vector (list 1 2 3))", "#(1 2 3)", env)
+	evalExpectAsString(t, "(vector-map (lambda (x) (* x x)) #(1 2 3))", "#(1 4 9)", env)
+	evalExpectBool(t, "(let ((w #(1))) (eq? w w))", true, env)
+
+	// Literals are part of the program, so they can't be changed; copies of them can
+	evalExpectAsString(t, "(define (lit) #(1 2))", "", env)
+	evalExpectError(t, "(vector-set! (lit) 0 'x)", "Vector literals are constants, so they can't be changed.", env)
+	evalExpectError(t, "(vector-fill! (lit) 0)", "Vector literals are constants, so they can't be changed.", env)
+	evalExpectAsString(t, "(lit)", "#(1 2)", env)
+	evalExpectAsString(t, "(define w (list->vector (vector->list (lit))))", "", env)
+	evalExpectAsString(t, "(vector-set! w 0 'x)", "", env)
+	evalExpectAsString(t, "w", "#(x 2)", env)
+
+	if _, err := ParseLine("#(1 . 2)"); err == nil {
+		t.Error("dotted vector literal parses without error")
+	}
+}
+
+func TestHashTables(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "(define h (make-hash-table))", "", env)
+	evalExpectBool(t, "(hash-table? h)", true, env)
+	evalExpectAsString(t, "(hash-table-set! h 'a 1)", "", env)
+	evalExpectAsString(t, "(hash-table-set! h \"a\" 2)", "", env)
+	evalExpectAsString(t, "(hash-table-set! h '(1 (2 x)) 3)", "", env)
+	evalExpectAsString(t, "(hash-table-set! h 12345678901234567890 4)", "", env)
+	evalExpectAsString(t, "(hash-table-set! h 1/2 5)", "", env)
+	evalExpectAsString(t, "(hash-table-set! h 2.5 6)", "", env)
+	evalExpectInt(t, "(hash-table-count h)", 6, env)
+
+	evalExpectInt(t, "(hash-table-ref h 'a)", 1, env)
+	evalExpectInt(t, "(hash-table-ref h \"a\")", 2, env)
+	evalExpectInt(t, "(hash-table-ref h (list 1 (list 2 'x)))", 3, env)
+	evalExpectInt(t, "(hash-table-ref h (* 1234567890123456789 10))", 4, env)
+	evalExpectInt(t, "(hash-table-ref h (/ 2 4))", 5, env)
+	evalExpectInt(t, "(hash-table-ref h 2.5)", 6, env)
+	evalExpectBool(t, "(hash-table-contains? h '(1 (2 \"x\")))", false, env)
+	evalExpectInt(t, "(hash-table-ref/default h 'missing 0)", 0, env)
+	evalExpectInt(t, "(hash-table-ref h 'missing (lambda () 9))", 9, env)
+	evalExpectError(t, "(hash-table-ref h 'missing)", "Key not found in hash table: missing", env)
+
+	evalExpectAsString(t, "(hash-table-update! h 'a (lambda (x) (+ x 10)))", "", env)
+	evalExpectInt(t, "(hash-table-ref h 'a)", 11, env)
+	evalExpectAsString(t, "(hash-table-update!/default h 'b (lambda (x) (+ x 10)) 0)", "", env)
+	evalExpectInt(t, "(hash-table-ref h 'b)", 10, env)
+	evalExpectAsString(t, "(hash-table-delete! h 'b)", "", env)
+	evalExpectBool(t, "(hash-table-contains? h 'b)", false, env)
+
+	evalExpectAsString(t, "(define total 0)", "", env)
+	evalExpectAsString(t, "(hash-table-walk h (lambda (k v) (set! total (+ total v))))", "", env)
+	evalExpectInt(t, "total", 11+2+3+4+5+6, env)
+	evalExpectInt(t, "(sum (hash-table-values h))", 11+2+3+4+5+6, env)
+	evalExpectInt(t, "(len (hash-table-keys h))", 6, env)
+	evalExpectAsString(t, "(let ((one (make-hash-table))) (hash-table-set! one 'k 'v) (hash-table->alist one))", "((k . v))", env)
+
+	// Identity tables tell apart lists that look alike
+	evalExpectAsString(t, "(define e (make-eq-hash-table))", "", env)
+	evalExpectAsString(t, "(define key (list 1 2))", "", env)
+	evalExpectAsString(t, "(hash-table-set! e key 'found)", "", env)
+	evalExpectAsString(t, "(hash-table-ref e key)", "'found", env)
+	evalExpectBool(t, "(hash-table-contains? e (list 1 2))", false, env)
+	evalExpectAsString(t, "(hash-table-set! e 7 'seven)", "", env)
+	evalExpectAsString(t, "(hash-table-ref e (+ 3 4))", "'seven", env)
+
+	// Keys are compared structurally all the way down, and anything without structure by identity
+	evalExpectAsString(t, "(define s (make-hash-table))", "", env)
+	evalExpectError(t, "(hash-table-set! s (cons 1 2) 'dotted)", "Invalid type. An improper list can't be a hash table key.", env)
+	evalExpectError(t, "(hash-table-set! s (list 1 (cons 2 3)) 'dotted)", "Invalid type. An improper list can't be a hash table key.", env)
+	evalExpectAsString(t, "(hash-table-set! s (list 1) 'one)", "", env)
+	evalExpectAsString(t, "(define (one) 1)", "", env)
+	evalExpectAsString(t, "(hash-table-set! s (list one) 'proc)", "", env)
+	evalExpectBool(t, "(hash-table-contains? s (list (lambda () 1)))", false, env)
+	evalExpectAsString(t, "(hash-table-ref s (list one))", "'proc", env)
+	evalExpectAsString(t, "(hash-table-set! s #(1 (2)) 'vec)", "", env)
+	evalExpectAsString(t, "(hash-table-ref s (vector 1 (list 2)))", "'vec", env)
+	evalExpectBool(t, "(hash-table-contains? s (list 1 (list 2)))", false, env)
+	evalExpectAsString(t, "(define-record-type point (make-point x y) point? (x point-x) (y point-y))", "", env)
+	evalExpectAsString(t, "(hash-table-set! s (make-point 1 2) 'point)", "", env)
+	evalExpectAsString(t, "(hash-table-ref s (make-point 1 2))", "'point", env)
+	evalExpectBool(t, "(hash-table-contains? s (make-point 2 1))", false, env)
+	evalExpectInt(t, "(hash-table-count s)", 4, env)
+}
+
+func TestRecords(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "(define-record-type point (make-point x y) point? (x point-x set-point-x!) (y point-y))", "", env)
+	evalExpectAsString(t, "(define p (make-point 1 'two))", "", env)
+	evalExpectAsString(t, "p", "#<record point x: 1 y: two>", env)
+	evalExpectAsString(t, "point", "#<record-type point>", env)
+	evalExpectBool(t, "(point? p)", true, env)
+	evalExpectBool(t, "(point? '(1 two))", false, env)
+	evalExpectInt(t, "(point-x p)", 1, env)
+	evalExpectAsString(t, "(set-point-x! p 5)", "", env)
+	evalExpectInt(t, "(point-x p)", 5, env)
+	evalExpectAsString(t, "(point-y p)", "'two", env)
+	evalExpectError(t, "(point-x 5)", "Invalid type. point-x needs a point record.", env)
+	evalExpectError(t, "(make-point 1)", "Invalid arguments. make-point expects exactly 2 arguments.", env)
+
+	// Fields left out of the constructor start as #f
+	evalExpectAsString(t, "(define-record-type node (make-node value) node? (value node-value) (next node-next set-node-next!))", "", env)
+	evalExpectBool(t, "(node-next (make-node 1))", false, env)
+	evalExpectBool(t, "(point? (make-node 1))", false, env)
+
+	evalExpectBool(t, "(eq? p p)", true, env)
+	evalExpectBool(t, "(eq? (make-point 1 2) (make-point 1 2))", false, env)
+	evalExpectBool(t, "(equal? (make-point 1 '(2)) (make-point 1 '(2)))", true, env)
+	evalExpectBool(t, "(equal? (make-point 1 2) (make-point 1 3))", false, env)
+	evalExpectBool(t, "(equal? '(1 #(2 \"3\")) (list 1 (vector 2 \"3\")))", true, env)
+	evalExpectBool(t, "(equal? '(1 2) '(1 2 3))", false, env)
+
+	evalExpectError(t, "(define-record-type bad (make-bad z) bad? (x bad-x))", "Constructor given to define-record-type takes 'z', which isn't a field.", env)
+}
+
+func TestPromises(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "(define count 0)", "", env)
+	evalExpectAsString(t, "(define p (delay (begin (set! count (+ count 1)) (* count 10))))", "", env)
+	evalExpectBool(t, "(promise? p)", true, env)
+	evalExpectInt(t, "count", 0, env)
+	evalExpectInt(t, "(force p)", 10, env)
+	evalExpectInt(t, "(force p)", 10, env)
+	evalExpectInt(t, "count", 1, env)
+
+	evalExpectInt(t, "(force 5)", 5, env)
+	evalExpectInt(t, "(force (make-promise 3))", 3, env)
+	evalExpectBool(t, "(let ((q (delay 1))) (eq? q (make-promise q)))", true, env)
+	evalExpectInt(t, "(force (let ((x 4)) (delay (* x x))))", 16, env)
+	evalExpectError(t, "(force (delay-force 1))", "Expression given to delay-force did not evaluate to a promise.", env)
+
+	// A long chain of delay-forces doesn't build up
+	evalExpectAsString(t, "(define (countdown n) (delay-force (if (eq? n 0) (delay 'done) (countdown (- n 1)))))", "", env)
+	evalExpectAsString(t, "(force (countdown 100000))", "'done", env)
+
+	evalExpectAsString(t, "(stream-take (stream-range 0 1000000000) 5)", "(0 1 2 3 4)", env)
+	evalExpectAsString(t, "(stream-take (stream-repeat 'a) 3)", "(a a a)", env)
+	evalExpectAsString(t, "(stream-take (stream-range 0 2) 5)", "(0 1)", env)
+	evalExpectInt(t, "(stream-car (stream-cdr (stream-range 1 5)))", 2, env)
+	evalExpectBool(t, "(stream-null? (stream-range 3 3))", true, env)
+	evalExpectBool(t, `(stream-null? (stream-cons 1 (error "not forced")))`, false, env)
+}
+
+func TestTrace(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "(define (fact n) (if (< n 2) 1 (* n (fact (- n 1)))))", "", env)
+	evalExpectAsString(t, "(define port (open-output-string))", "", env)
+	evalExpectAsString(t, "(trace-output-port port)", "", env)
+	evalExpectBool(t, "(output-port? (trace-output-port))", true, env)
+
+	evalExpectAsString(t, "(trace fact)", "", env)
+	evalExpectInt(t, "(fact 3)", 6, env)
+	evalExpectAsString(t, "(get-output-string port)", `">(fact 3)\n  >(fact 2)\n    >(fact 1)\n    <1\n  <2\n<6\n"`, env)
+
+	// Built-in procedures can be traced too, and untracing restores them
+	evalExpectAsString(t, "(trace +)", "", env)
+	evalExpectAsString(t, "(untrace fact)", "", env)
+	evalExpectInt(t, "(+ 1 (fact 1))", 2, env)
+	evalExpectAsString(t, "(untrace +)", "", env)
+	evalExpectInt(t, "(+ 1 (fact 2))", 3, env)
+	evalExpectAsString(t, "(get-output-string port)", `">(fact 3)\n  >(fact 2)\n    >(fact 1)\n    <1\n  <2\n<6\n>(+ 1 1)\n<2\n"`, env)
+	evalExpectAsString(t, "fact", "#<procedure:fact>", env)
+
+	// Arguments are passed as they were evaluated, not evaluated again
+	evalExpectAsString(t, "(define (pair a b) (cons a b))", "", env)
+	evalExpectAsString(t, "(trace pair)", "", env)
+	evalExpectAsString(t, "(define port (open-output-string))", "", env)
+	evalExpectAsString(t, "(trace-output-port port)", "", env)
+	evalExpectAsString(t, "(pair 'x '(1 2))", "(x 1 2)", env)
+	evalExpectAsString(t, "(apply pair '(y (3)))", "(y 3)", env)
+	evalExpectAsString(t, "(get-output-string port)", `">(pair x (1 2))\n<(x 1 2)\n>(pair y (3))\n<(y 3)\n"`, env)
+
+	// Tail calls between traced procedures don't grow the stack, and are shown at the same depth
+	evalExpectAsString(t, "(define (count-down n) (if (< n 1) 0 (count-down (- n 1))))", "", env)
+	evalExpectAsString(t, "(trace count-down)", "", env)
+	evalExpectAsString(t, "(define port (open-output-string))", "", env)
+	evalExpectAsString(t, "(trace-output-port port)", "", env)
+	evalExpectAsString(t, "(count-down 2)", "0", env)
+	evalExpectAsString(t, "(get-output-string port)", `">(count-down 2)\n>(count-down 1)\n>(count-down 0)\n<0\n"`, env)
+	evalExpectAsString(t, "(trace-output-port (open-output-string))", "", env)
+	sexps, _ := ParseLine("(count-down 10000)")
+	if _, err := EvalContext(context.Background(), sexps[0], env, Limits{MaxStackDepth: 10}); err != nil {
+		t.Errorf("(count-down 10000) gives error %v, want no error\n", err)
+	}
+
+	evalExpectError(t, "(trace 5)", "trace takes the names of procedures, and 5 isn't a name.", env)
+	evalExpectError(t, "(trace pi)", "Only procedures can be traced, and pi is 3.141592653589793.", env)
+	evalExpectError(t, "(get-output-string (current-output-port))", "Invalid type. Can only get the output of a port made by open-output-string.", env)
+}
+
+func TestOutput(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+
+	evalExpectAsString(t, "(define port (open-output-string))", "", env)
+	evalExpectAsString(t, `(display "hi \"there\"" port)`, "", env)
+	evalExpectAsString(t, "(newline port)", "", env)
+	evalExpectAsString(t, `(write "hi \"there\"" port)`, "", env)
+	evalExpectAsString(t, "(write '(a 1.5) port)", "", env)
+	evalExpectAsString(t, "(get-output-string port)", `"hi \"there\"\n\"hi \\\"there\\\"\"(a 1.5)"`, env)
+
+	evalExpectError(t, "(display)", "Invalid arguments. Expecting an object and an optional port.", env)
+	evalExpectError(t, "(write 1 2)", "Invalid type. Expecting a port.", env)
+	evalExpectError(t, "(newline port port)", "Invalid arguments. Expecting an optional port.", env)
+
+	// Without a port, they write to the interpreter's output
+	var out strings.Builder
+	interp := New(WithOutput(&out))
+	if _, err := interp.EvalString(`(display "x = ") (write "one") (newline)`); err != nil {
+		t.Fatalf("Writing to the current output port gives error %v\n", err)
+	}
+	if got := out.String(); got != "x = \"one\"\n" {
+		t.Errorf("Output is %q, want %q\n", got, "x = \"one\"\n")
+	}
+}
+
+func TestProfiler(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+	evalExpectAsString(t, "(define (fact n) (if (< n 2) 1 (* n (fact (- n 1)))))", "", env)
+	evalExpectAsString(t, "(define (loop n) (if (< n 1) 0 (begin (fact 5) (loop (- n 1)))))", "", env)
+
+	profiler := NewProfiler()
+	sexps, _ := ParseLine("(loop 3)")
+	if result, err := profiler.Eval(sexps[0], env); err != nil || result != PTInt(0) {
+		t.Fatalf("profiling (loop 3) gives %v, %v, want 0\n", result, err)
+	}
+
+	entries := make(map[string]ProfileEntry)
+	for _, e := range profiler.Entries() {
+		entries[e.Name] = e
+	}
+	// loop calls itself in tail position, and fact calls itself four times for each of the three calls from loop
+	if calls := entries["loop"].Calls; calls != 4 {
+		t.Errorf("loop was called %d times, want 4\n", calls)
+	}
+	if calls := entries["fact"].Calls; calls != 15 {
+		t.Errorf("fact was called %d times, want 15\n", calls)
+	}
+	if calls := entries["*"].Calls; calls != 12 {
+		t.Errorf("* was called %d times, want 12\n", calls)
+	}
+	loop, fact := entries["loop"], entries["fact"]
+	if loop.Steps <= fact.Steps || fact.Steps <= fact.ExclusiveSteps || loop.Steps != entries[topLevelName].Steps-entries[topLevelName].ExclusiveSteps {
+		t.Errorf("profile has inconsistent steps: %+v\n", profiler.Entries())
+	}
+
+	var pprof strings.Builder
+	if err := profiler.WritePprof(&pprof); err != nil {
+		t.Fatal("writing a pprof profile gives error:", err)
+	}
+	unzipped, err := gzip.NewReader(strings.NewReader(pprof.String()))
+	if err != nil {
+		t.Fatal("pprof profile isn't gzipped:", err)
+	}
+	raw, _ := io.ReadAll(unzipped)
+	for _, name := range []string{"steps", "fact", "loop"} {
+		if !strings.Contains(string(raw), name) {
+			t.Errorf("pprof profile doesn't mention %s\n", name)
+		}
+	}
+
+	var report strings.Builder
+	profiler.Report(&report)
+	reported := false
+	for _, line := range strings.Split(report.String(), "\n") {
+		if fields := strings.Fields(line); len(fields) == 6 && fields[5] == "fact" {
+			reported = fields[0] == "15"
+		}
+	}
+	if !reported {
+		t.Errorf("profiler reports\n%s\nwant fact called 15 times\n", report.String())
+	}
+
+	// Deep recursion, and escapes that throw away part of the stack, still give stacks of the procedures actually running
+	evalExpectAsString(t, "(define (count-up n) (if (< n 1) 0 (+ 1 (count-up (- n 1)))))", "", env)
+	evalExpectAsString(t, "(define (bail n) (if (< n 1) (raise 'out) (+ 1 (bail (- n 1)))))", "", env)
+	profiler = NewProfiler()
+	sexps, _ = ParseLine("(+ (count-up 2000) (guard (e (#t (fact 3))) (bail 50)))")
+	if result, err := profiler.Eval(sexps[0], env); err != nil || result != PTInt(2006) {
+		t.Fatalf("profiling count-up and bail gives %v, %v, want 2006\n", result, err)
+	}
+	for _, s := range profiler.samples {
+		joined := strings.Join(s.Stack, " ")
+		if len(s.Stack) > 3 || strings.Contains(joined, "bail") && strings.Contains(joined, "fact") {
+			t.Errorf("profile has a sample for the stack %v\n", s.Stack)
+		}
+	}
+	entries = make(map[string]ProfileEntry)
+	for _, e := range profiler.Entries() {
+		entries[e.Name] = e
+	}
+	if calls := entries["count-up"].Calls; calls != 2001 {
+		t.Errorf("count-up was called %d times, want 2001\n", calls)
+	}
+	if fact, bail := entries["fact"], entries["bail"]; fact.Calls != 3 || bail.Calls != 51 || fact.Steps+bail.Steps >= entries[topLevelName].Steps {
+		t.Errorf("profile has inconsistent entries: %+v\n", profiler.Entries())
+	}
+}
+
+func TestEvalContext(t *testing.T) {
+	env := NewEnv()
+	InitGlobalEnv(env)
+	evalExpectAsString(t, "(define (forever) (forever))", "", env)
+	evalExpectAsString(t, "(define (count-up n) (if (< n 1) 0 (+ 1 (count-up (- n 1)))))", "", env)
+
+	evalLimited := func(ctx context.Context, expr string, limits Limits) (Expression, error) {
+		sexps, parseErr := ParseLine(expr)
+		if parseErr != nil {
+			t.Fatal(expr, "parsing gives error:", parseErr.Error())
+		}
+		return EvalContext(ctx, sexps[0], env, limits)
+	}
+	expectLimit := func(err error, cause error, expr string) {
+		var evalErr *EvalError
+		if !errors.Is(err, cause) || !errors.As(err, &evalErr) || evalErr.Kind != LimitError {
+			t.Errorf("%s gives error %v, want a %s for %v\n", expr, err, LimitError, cause)
+		}
+	}
+
+	// Within its limits, evaluation goes as usual
+	if result, err := evalLimited(context.Background(), "(count-up 20)", Limits{MaxSteps: 10000, MaxStackDepth: 100}); err != nil || result != PTInt(20) {
+		t.Errorf("(count-up 20) gives %v, %v, want 20\n", result, err)
+	}
+
+	_, err := evalLimited(context.Background(), "(forever)", Limits{MaxSteps: 1000})
+	expectLimit(err, ErrStepLimit, "(forever)")
+	_, err = evalLimited(context.Background(), "(count-up 1000)", Limits{MaxStackDepth: 100})
+	expectLimit(err, ErrStackDepthLimit, "(count-up 1000)")
+
+	// Macro expansion counts too
+	evalExpectAsString(t, "(define-syntax nest (syntax-rules () ((_ x) (list (nest x)))))", "", env)
+	_, err = evalLimited(context.Background(), "(nest 1)", Limits{MaxStackDepth: 100})
+	expectLimit(err, ErrStackDepthLimit, "(nest 1)")
+	_, err = evalLimited(context.Background(), "(nest 1)", Limits{MaxSteps: 1000})
+	expectLimit(err, ErrStepLimit, "(nest 1)")
+
+	// The program can't catch being stopped
+	_, err = evalLimited(context.Background(), "(guard (e (#t 0)) (forever))", Limits{MaxSteps: 1000})
+	expectLimit(err, ErrStepLimit, "(forever) in a guard")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = evalLimited(ctx, "(forever)", Limits{})
+	expectLimit(err, context.DeadlineExceeded, "(forever) with a timeout")
+
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	_, err = evalLimited(ctx, "(count-up 5)", Limits{})
+	expectLimit(err, context.Canceled, "(count-up 5) once cancelled")
+}
+
+func TestInterpreter(t *testing.T) {
+	var out strings.Builder
+	interp := New(WithOutput(&out), WithLimits(Limits{MaxSteps: 10000}))
+
+	interp.Define("double", NewGoProc("double", func(args ...Expression) (Expression, error) {
+		if len(args) != 1 {
+			return nil, errorf(ArityError, "Invalid arguments. Expecting one argument.")
+		}
+		n, ok := args[0].(PTInt)
+		if !ok {
+			return nil, errorf(TypeError, "Invalid type. Expecting an integer.")
+		}
+		return n * 2, nil
+	}))
+	result, err := interp.EvalString("(define (f x) (double (+ x 1))) (f 4)")
+	if err != nil || result != PTInt(10) {
+		t.Errorf("(f 4) gives %v, %v, want 10\n", result, err)
+	}
+	if f, ok := interp.Lookup("f"); !ok || f.(*Proc).Name != "f" {
+		t.Errorf("Lookup f gives %v, %v, want f\n", f, ok)
+	}
+	if _, ok := interp.Lookup("undefined-thing"); ok {
+		t.Errorf("Lookup of an unbound name succeeds\n")
+	}
+
+	// Interpreters don't share definitions
+	if _, ok := New().Lookup("f"); ok {
+		t.Errorf("f is bound in a new Interpreter\n")
+	}
+
+	// Positions in errors name the file read from
+	_, err = interp.EvalReader(namedReader{strings.NewReader("(define (g x)\n  (+ x 1))\n(g 'a)"), "prog.gt"})
+	var evalErr *EvalError
+	if !errors.As(err, &evalErr) || evalErr.Pos == nil || evalErr.Pos.String() != "prog.gt:2:3" {
+		t.Errorf("(g 'a) gives error %v, want one at prog.gt:2:3\n", err)
+	}
+
+	_, err = interp.EvalString("(define (forever) (forever)) (forever)")
+	if !errors.Is(err, ErrStepLimit) {
+		t.Errorf("(forever) gives error %v, want %v\n", err, ErrStepLimit)
+	}
+
+	interp.EvalString("(trace f) (f 1)")
+	if out.String() != ">(f 1)\n<4\n" {
+		t.Errorf("Output is %q, want the trace of (f 1)\n", out.String())
+	}
+
+	// Profilers can be given when the Interpreter is made, or for a while after
+	profiler, later := NewProfiler(), NewProfiler()
+	profiled := New(WithProfiler(profiler))
+	stop := profiled.Profile(later)
+	profiled.EvalString("(+ 1 2)")
+	stop()
+	profiled.EvalString("(* 1 2)")
+	if len(later.Entries()) == 0 || len(later.Entries()) >= len(profiler.Entries()) {
+		t.Errorf("Profilers saw %+v and %+v, want the second to have stopped before (* 1 2)\n", profiler.Entries(), later.Entries())
+	}
+
+	// Expansion that never ends is an error, even without limits
+	_, err = New().EvalString("(define-syntax nest (syntax-rules () ((_ x) (list (nest x))))) (nest 1)")
+	if !errors.As(err, &evalErr) || evalErr.Kind != SyntaxError {
+		t.Errorf("(nest 1) gives error %v, want a %s\n", err, SyntaxError)
+	}
+	_, err = New().EvalString("(define-syntax forever (syntax-rules () ((_) (forever)))) (forever)")
+	if !errors.As(err, &evalErr) || evalErr.Kind != SyntaxError {
+		t.Errorf("(forever) gives error %v, want a %s\n", err, SyntaxError)
+	}
+
+	if _, err := interp.EvalString("(exit)"); err != ErrExit {
+		t.Errorf("(exit) gives error %v, want %v\n", err, ErrExit)
+	}
+}
+
+type namedReader struct {
+	io.Reader
+	name string
+}
+
+func (r namedReader) Name() string {
+	return r.name
+}
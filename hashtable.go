@@ -0,0 +1,139 @@
+package golftalk
+
+import (
+	"fmt"
+)
+
+// PTHashTable maps keys to values.
+// Keys are compared like equal?, so lists with the same elements are the same key, unless the table compares them by identity, like eq?.
+// Hash tables can be changed in place, so they're always handled by pointer.
+type PTHashTable struct {
+	Identity bool
+	entries  map[interface{}]HashEntry
+}
+
+// HashEntry keeps the key as it was given, since the map is keyed on a comparable stand-in for it.
+type HashEntry struct {
+	Key   Expression
+	Value Expression
+}
+
+// Stand-ins for keys which Go can't compare the way golftalk does
+type bigKey string
+type ratKey string
+
+// pairKey stands in for a list by the keys of its elements, so lists whose elements are equal have equal keys.
+// Anything without a structure of its own, like a procedure, is its own key, so it's only ever equal to itself.
+type pairKey struct {
+	head, tail interface{}
+}
+
+type vectorKey struct {
+	items interface{}
+}
+
+type recordKey struct {
+	recordType *RecordType
+	values     interface{}
+}
+
+func NewHashTable(identity bool) *PTHashTable {
+	return &PTHashTable{identity, make(map[interface{}]HashEntry)}
+}
+
+// hashKey returns the map key standing in for a golftalk key.
+func (h *PTHashTable) hashKey(key Expression) (interface{}, error) {
+	switch k := key.(type) {
+	case PTBigInt:
+		// Exact numbers are compared by value, even when big
+		return bigKey(k.String()), nil
+	case PTRational:
+		return ratKey(k.String()), nil
+	case CoreFunc, *PTValues:
+		return nil, errorf(TypeError, "Invalid type. %s can't be a hash table key.", SexpToString(key))
+	}
+	if h.Identity {
+		return key, nil
+	}
+
+	switch k := key.(type) {
+	case *SexpPair:
+		if k == EmptyList {
+			return k, nil
+		}
+		items, listErr := ToSlice(k)
+		if listErr != nil {
+			return nil, errorf(TypeError, "Invalid type. An improper list can't be a hash table key.")
+		}
+		return h.listKey(items)
+	case *PTVector:
+		items, err := h.listKey(k.Items)
+		return vectorKey{items}, err
+	case *PTRecord:
+		values, err := h.listKey(k.Values)
+		return recordKey{k.Type, values}, err
+	}
+	return key, nil
+}
+
+// listKey builds the key of a list of the given items.
+func (h *PTHashTable) listKey(items []Expression) (interface{}, error) {
+	var key interface{} = EmptyList
+	for i := len(items) - 1; i >= 0; i-- {
+		head, err := h.hashKey(items[i])
+		if err != nil {
+			return nil, err
+		}
+		key = pairKey{head, key}
+	}
+	return key, nil
+}
+
+// Get looks up a key, reporting whether it was there.
+func (h *PTHashTable) Get(key Expression) (Expression, bool, error) {
+	hashKey, err := h.hashKey(key)
+	if err != nil {
+		return nil, false, err
+	}
+	entry, ok := h.entries[hashKey]
+	return entry.Value, ok, nil
+}
+
+func (h *PTHashTable) Set(key, value Expression) error {
+	hashKey, err := h.hashKey(key)
+	if err != nil {
+		return err
+	}
+	h.entries[hashKey] = HashEntry{key, value}
+	return nil
+}
+
+func (h *PTHashTable) Delete(key Expression) error {
+	hashKey, err := h.hashKey(key)
+	if err != nil {
+		return err
+	}
+	delete(h.entries, hashKey)
+	return nil
+}
+
+// Entries returns every key and value in the table, in no particular order.
+func (h *PTHashTable) Entries() []HashEntry {
+	entries := make([]HashEntry, 0, len(h.entries))
+	for _, entry := range h.entries {
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (h *PTHashTable) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return h, env, nil
+}
+
+func (h *PTHashTable) String() string {
+	return fmt.Sprintf("#<hash-table %d>", len(h.entries))
+}
+
+func (_ *PTHashTable) IsLiteral() bool {
+	return true
+}
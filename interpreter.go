@@ -0,0 +1,162 @@
+package golftalk
+
+import (
+	"context"
+	"io"
+	"os"
+	"strings"
+)
+
+// Interpreter evaluates golftalk programs in a global Env of its own.
+// Interpreters share no state, but one Interpreter must not be used by several goroutines at once.
+type Interpreter struct {
+	env      *Env
+	limits   Limits
+	watchers stepWatchers
+
+	schemeNames bool
+	output      io.Writer
+}
+
+// Option configures an Interpreter made by New.
+type Option func(*Interpreter)
+
+// WithSchemeNames chooses whether the actual Scheme names, like car and cdr, are bound alongside golftalk's own. They are by default.
+func WithSchemeNames(use bool) Option {
+	return func(in *Interpreter) {
+		in.schemeNames = use
+	}
+}
+
+// WithOutput sends what programs write to the current output port, and what traced procedures report, to w instead of stdout.
+func WithOutput(w io.Writer) Option {
+	return func(in *Interpreter) {
+		in.output = w
+	}
+}
+
+// WithLimits bounds every evaluation by the Interpreter; see EvalContext.
+// The limits apply to each call to an Eval method as a whole, however many expressions it evaluates.
+func WithLimits(limits Limits) Option {
+	return func(in *Interpreter) {
+		in.limits = limits
+	}
+}
+
+// WithDebugger has every evaluation by the Interpreter stop at the Debugger's breakpoints.
+func WithDebugger(d *Debugger) Option {
+	return func(in *Interpreter) {
+		in.watchers = append(in.watchers, d)
+	}
+}
+
+// WithProfiler has the costs of every evaluation by the Interpreter charged to the Profiler.
+func WithProfiler(p *Profiler) Option {
+	return func(in *Interpreter) {
+		in.watchers = append(in.watchers, p)
+	}
+}
+
+// New makes an Interpreter with a freshly initialized global Env.
+func New(opts ...Option) *Interpreter {
+	in := &Interpreter{env: NewEnv(), schemeNames: true, output: os.Stdout}
+	for _, opt := range opts {
+		opt(in)
+	}
+	initGlobalEnv(in.env, in.schemeNames, in.output)
+	return in
+}
+
+// Env returns the Interpreter's global Env.
+func (in *Interpreter) Env() *Env {
+	return in.env
+}
+
+// Define binds name to value in the global Env, replacing any binding it had.
+// Go functions can be defined with NewGoProc.
+func (in *Interpreter) Define(name string, value Expression) {
+	if proc, ok := value.(Procedure); ok {
+		proc.GiveName(name)
+	}
+	in.env.Dict[Symbol(name)] = value
+}
+
+// Lookup returns the value bound to name in the global Env, if it's bound.
+func (in *Interpreter) Lookup(name string) (Expression, bool) {
+	value, err := in.env.Get(Symbol(name))
+	return value, err == nil
+}
+
+// Eval evaluates an expression in the global Env.
+func (in *Interpreter) Eval(expr Expression) (Expression, error) {
+	return in.EvalContext(context.Background(), expr)
+}
+
+// EvalContext evaluates an expression in the global Env, stopping early if ctx is done; see the package's EvalContext.
+func (in *Interpreter) EvalContext(ctx context.Context, expr Expression) (Expression, error) {
+	return evalWatched(expr, in.env, in.watcher(ctx))
+}
+
+// EvalString evaluates each expression in src in turn, and returns the value of the last.
+func (in *Interpreter) EvalString(src string) (Expression, error) {
+	return in.EvalReaderContext(context.Background(), strings.NewReader(src))
+}
+
+// EvalReader evaluates each expression read from r in turn, and returns the value of the last.
+// If r has a Name method, as files do, positions in errors name it.
+func (in *Interpreter) EvalReader(r io.Reader) (Expression, error) {
+	return in.EvalReaderContext(context.Background(), r)
+}
+
+// EvalReaderContext is EvalReader, stopping early if ctx is done.
+// Nothing is evaluated unless everything read parses.
+func (in *Interpreter) EvalReaderContext(ctx context.Context, r io.Reader) (Expression, error) {
+	scanner := NewScanner(r)
+	if named, ok := r.(interface{ Name() string }); ok {
+		scanner.File = named.Name()
+	}
+	sexps, err := Parse(scanner)
+	if err != nil {
+		return nil, err
+	}
+
+	// The limits hold for the lot
+	watcher := in.watcher(ctx)
+	var result Expression = PTBlank
+	for _, sexp := range sexps {
+		if result, err = evalWatched(sexp, in.env, watcher); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// watcher returns what should watch an evaluation bounded by ctx, or nil if nothing need.
+func (in *Interpreter) watcher(ctx context.Context) stepWatcher {
+	watchers := in.watchers
+	if ctx.Done() != nil || in.limits != (Limits{}) {
+		watchers = append(watchers[:len(watchers):len(watchers)], &limiter{ctx: ctx, stop: ctx.Done(), limits: in.limits})
+	}
+	if len(watchers) == 0 {
+		return nil
+	}
+	return watchers
+}
+
+// Profile has the costs of the Interpreter's evaluations charged to p too, until the function returned is called.
+func (in *Interpreter) Profile(p *Profiler) (stop func()) {
+	in.watchers = append(in.watchers, p)
+	return func() {
+		for i, watcher := range in.watchers {
+			if watcher == p {
+				in.watchers = append(in.watchers[:i:i], in.watchers[i+1:]...)
+				return
+			}
+		}
+	}
+}
+
+// NewGoProc makes a procedure which calls a Go function with its evaluated arguments.
+func NewGoProc(name string, fn func(args ...Expression) (Expression, error)) *GoProc {
+	return &GoProc{name, fn}
+}
@@ -0,0 +1,79 @@
+package golftalk
+
+import (
+	"context"
+	"errors"
+)
+
+// The errors wrapped by the LimitErrors EvalContext returns when its limits are exceeded.
+var (
+	ErrStepLimit       = errors.New("step limit exceeded")
+	ErrStackDepthLimit = errors.New("stack depth limit exceeded")
+)
+
+// Limits bound how much work an evaluation may do. A limit of zero means no limit.
+type Limits struct {
+	MaxSteps      int // how many times round the Eval loop
+	MaxStackDepth int // how many frames may be on the Stack at once
+}
+
+// EvalContext is Eval, stopped early with a LimitError if ctx is done or the limits are exceeded.
+// The error wraps ctx.Err(), ErrStepLimit or ErrStackDepthLimit, so errors.Is tells them apart.
+// The program's exception handlers can't catch these errors, and the after thunks of any dynamic-winds it's in aren't run.
+func EvalContext(ctx context.Context, inVal Expression, inEnv *Env, limits Limits) (Expression, error) {
+	return evalWatched(inVal, inEnv, &limiter{ctx: ctx, stop: ctx.Done(), limits: limits})
+}
+
+// limiter stops evaluations that go on too long or too deep.
+type limiter struct {
+	ctx    context.Context
+	stop   <-chan struct{} // ctx.Done(), which needn't be looked up every step
+	limits Limits
+	steps  int
+}
+
+func (l *limiter) beforeStep(expr Expression, env *Env, stack Stack) error {
+	l.steps++
+	if l.limits.MaxSteps > 0 && l.steps > l.limits.MaxSteps {
+		return limitError(ErrStepLimit, expr, stack, "Evaluation took more than %d steps.", l.limits.MaxSteps)
+	}
+	if l.limits.MaxStackDepth > 0 && len(stack) > l.limits.MaxStackDepth {
+		return limitError(ErrStackDepthLimit, expr, stack, "Stack grew deeper than %d frames.", l.limits.MaxStackDepth)
+	}
+	select {
+	case <-l.stop:
+		return limitError(l.ctx.Err(), expr, stack, "Evaluation stopped: %s.", l.ctx.Err())
+	default:
+		return nil
+	}
+}
+
+// beforeExpand counts macro expansions as steps, and nested expansions as frames.
+func (l *limiter) beforeExpand(form Expression, depth int) error {
+	l.steps++
+	if l.limits.MaxSteps > 0 && l.steps > l.limits.MaxSteps {
+		return limitError(ErrStepLimit, form, nil, "Evaluation took more than %d steps.", l.limits.MaxSteps)
+	}
+	if l.limits.MaxStackDepth > 0 && depth > l.limits.MaxStackDepth {
+		return limitError(ErrStackDepthLimit, form, nil, "Macro expansion nested deeper than %d.", l.limits.MaxStackDepth)
+	}
+	select {
+	case <-l.stop:
+		return limitError(l.ctx.Err(), form, nil, "Evaluation stopped: %s.", l.ctx.Err())
+	default:
+		return nil
+	}
+}
+
+func (_ *limiter) done() {
+	//dont care
+	return
+}
+
+// limitError makes the LimitError for cause, saying where evaluation got to.
+// The trace is left out, since the Stack may be very deep by now.
+func limitError(cause error, expr Expression, stack Stack, format string, args ...interface{}) *EvalError {
+	err := errorf(LimitError, format, args...)
+	err.Err, err.Expr, err.Pos = cause, expr, sourcePos(expr, stack)
+	return err
+}
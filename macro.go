@@ -0,0 +1,494 @@
+package golftalk
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// RenamedSymbol is an identifier introduced by a macro template, rather than taken from the macro's input.
+// Binding forms bind it under its fresh Name, so it can never capture a variable of the macro's user.
+// Anywhere it isn't bound that way, it means whatever Orig meant where the macro was defined.
+type RenamedSymbol struct {
+	Name Symbol
+	Orig Expression
+	Env  *Env
+
+	// anchor is bound in the Env where its macro was defined, if that was within a body; see origEnv
+	anchor Symbol
+}
+
+//*RenamedSymbol should implement Expression
+var _ Expression = &RenamedSymbol{}
+
+var renameCounter int64
+
+func newRenamedSymbol(orig Expression, macro *Macro) *RenamedSymbol {
+	// The space keeps fresh names from ever colliding with a symbol the reader could produce
+	name := Symbol(fmt.Sprintf("%s %d", baseSymbol(orig), atomic.AddInt64(&renameCounter, 1)))
+	return &RenamedSymbol{name, orig, macro.Env, macro.anchor}
+}
+
+// origEnv finds the Env where the identifier's macro was defined, from the Env it's evaluated in.
+// A macro defined in a body was defined in a scope of the expander's, which has no values; the Env that scope became is the one that binds the anchor.
+func (r *RenamedSymbol) origEnv(env *Env) *Env {
+	if r.anchor == "" {
+		return r.Env
+	}
+	for tmpEnv := env; tmpEnv != nil; tmpEnv = tmpEnv.Outer {
+		if _, ok := tmpEnv.Dict[r.anchor]; ok {
+			return tmpEnv
+		}
+	}
+	return env
+}
+
+func (r *RenamedSymbol) Eval(stack *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	if lookup, lookupErr := env.Get(r.Name); lookupErr == nil {
+		return lookup, env, nil
+	}
+	result, _, err = r.Orig.Eval(stack, r.origEnv(env))
+	return result, env, err
+}
+
+// Set assigns to whatever binding the identifier refers to in env.
+func (r *RenamedSymbol) Set(env *Env, val Expression) error {
+	if env.Set(r.Name, val) == nil {
+		return nil
+	}
+	return setIdentifier(r.origEnv(env), r.Orig, val)
+}
+
+func (r *RenamedSymbol) String() string {
+	return r.Orig.String()
+}
+
+func (_ *RenamedSymbol) IsLiteral() bool {
+	return false
+}
+
+// isIdentifier reports whether an expression can name a variable.
+func isIdentifier(e Expression) bool {
+	switch e.(type) {
+	case Symbol, *RenamedSymbol:
+		return true
+	}
+	return false
+}
+
+// bindingName returns the name under which a binding form should bind an identifier.
+func bindingName(e Expression) (Symbol, bool) {
+	switch id := e.(type) {
+	case Symbol:
+		return id, true
+	case *RenamedSymbol:
+		return id.Name, true
+	}
+	return "", false
+}
+
+// baseSymbol strips every layer of renaming from an identifier.
+func baseSymbol(e Expression) Symbol {
+	for {
+		switch id := e.(type) {
+		case Symbol:
+			return id
+		case *RenamedSymbol:
+			e = id.Orig
+		default:
+			return ""
+		}
+	}
+}
+
+// setIdentifier assigns to the nearest binding of an identifier.
+func setIdentifier(env *Env, id Expression, val Expression) error {
+	if renamed, ok := id.(*RenamedSymbol); ok {
+		return renamed.Set(env, val)
+	}
+	return env.Set(id.(Symbol), val)
+}
+
+type syntaxRule struct {
+	pattern  *SexpPair
+	template Expression
+}
+
+// Macro is a syntax-rules transformer.
+// Its uses are expanded before the code they're in is evaluated, so it's never a value a program can get hold of.
+type Macro struct {
+	Name     string
+	Ellipsis Symbol
+	Literals []Symbol
+	Rules    []syntaxRule
+	Env      *Env
+
+	// anchor is bound where the macro was defined, if that was within a body
+	anchor Symbol
+}
+
+// NewSyntaxRules builds a macro from the arguments of a syntax-rules form, closing over env.
+func NewSyntaxRules(args *SexpPair, env *Env) (*Macro, error) {
+	macro := &Macro{Ellipsis: "...", Env: env}
+
+	// A custom ellipsis may come before the literals
+	if args != EmptyList && isIdentifier(args.val) {
+		macro.Ellipsis = baseSymbol(args.val)
+		args, _ = args.next.(*SexpPair)
+	}
+
+	if args == EmptyList {
+		return nil, errorf(SyntaxError, "syntax-rules needs a list of literals.")
+	}
+	literals, literalsOk := args.val.(*SexpPair)
+	literalSlice, listErr := ToSlice(literals)
+	if !literalsOk || listErr != nil {
+		return nil, errorf(SyntaxError, "syntax-rules literals must be a list.")
+	}
+	for _, literal := range literalSlice {
+		if !isIdentifier(literal) {
+			return nil, errorf(SyntaxError, "syntax-rules literals must be symbols.")
+		}
+		macro.Literals = append(macro.Literals, baseSymbol(literal))
+	}
+
+	rules, listErr := ToSlice(args.next.(*SexpPair))
+	if listErr != nil {
+		return nil, errorf(SyntaxError, "syntax-rules rules must be a list.")
+	}
+	for i, rule := range rules {
+		rulePair, ruleOk := rule.(*SexpPair)
+		if length, _ := rulePair.Len(); !ruleOk || length != 2 {
+			return nil, errorf(SyntaxError, "syntax-rules rule #%d must be a pattern and a template.", i+1)
+		}
+		pattern, patternOk := rulePair.val.(*SexpPair)
+		if !patternOk || pattern == EmptyList {
+			return nil, errorf(SyntaxError, "syntax-rules rule #%d has a pattern that isn't a list.", i+1)
+		}
+		macro.Rules = append(macro.Rules, syntaxRule{pattern, Get(rulePair, 1)})
+	}
+
+	return macro, nil
+}
+
+// Expand transcribes the template of the first rule whose pattern matches the arguments of a use of the macro.
+func (m *Macro) Expand(args *SexpPair) (Expression, error) {
+	for _, rule := range m.Rules {
+		// The keyword position of the pattern is ignored
+		bindings := make(map[Expression]*matchBinding)
+		if m.match(rule.pattern.next, args, bindings) {
+			renames := make(map[Expression]*RenamedSymbol)
+			return m.transcribe(rule.template, bindings, renames, m.Ellipsis)
+		}
+	}
+
+	name := m.displayName()
+	return nil, errorf(SyntaxError, "Bad syntax: no pattern of '%s' matches %s.", name, SexpToString(&SexpPair{Symbol(name), args, false, nil, nil}))
+}
+
+// matchBinding is what a pattern variable matched.
+// Variables under an ellipsis match a sequence of bindings, one per repetition.
+type matchBinding struct {
+	expr  Expression
+	seq   []*matchBinding
+	isSeq bool
+}
+
+func (m *Macro) isEllipsis(e Expression) bool {
+	return isIdentifier(e) && baseSymbol(e) == m.Ellipsis
+}
+
+func (m *Macro) isLiteral(e Expression) bool {
+	if !isIdentifier(e) {
+		return false
+	}
+	for _, literal := range m.Literals {
+		if baseSymbol(e) == literal {
+			return true
+		}
+	}
+	return false
+}
+
+func (m *Macro) match(pattern Expression, form Expression, bindings map[Expression]*matchBinding) bool {
+	switch {
+	case m.isLiteral(pattern):
+		return isIdentifier(form) && baseSymbol(form) == baseSymbol(pattern)
+	case isIdentifier(pattern):
+		if baseSymbol(pattern) != "_" {
+			bindings[pattern] = &matchBinding{expr: form}
+		}
+		return true
+	}
+
+	patternPair, wasPair := pattern.(*SexpPair)
+	if !wasPair {
+		// Some other datum, which must match exactly
+		return form == pattern
+	}
+
+	formPair, wasPair := form.(*SexpPair)
+	if !wasPair || (formPair != EmptyList && formPair.literal) {
+		return false
+	}
+
+	if patternPair == EmptyList {
+		return formPair == EmptyList
+	}
+
+	// Is this element repeated?
+	if next, ok := patternPair.next.(*SexpPair); ok && next != EmptyList && m.isEllipsis(next.val) {
+		rest := next.next
+
+		// Everything after the ellipsis has to match the end of the form, so find out how much of the form that is
+		minRest := 0
+		for tail, ok := rest.(*SexpPair); ok && tail != EmptyList; tail, ok = tail.next.(*SexpPair) {
+			minRest++
+		}
+		var items []Expression
+		formRest := Expression(formPair)
+		for tail, ok := formPair, true; ok && tail != EmptyList; tail, ok = tail.next.(*SexpPair) {
+			items = append(items, tail.val)
+		}
+		repeats := len(items) - minRest
+		if repeats < 0 {
+			return false
+		}
+
+		seqs := make(map[Expression][]*matchBinding)
+		for _, v := range m.patternVars(patternPair.val) {
+			seqs[v] = []*matchBinding{}
+		}
+		for i := 0; i < repeats; i++ {
+			itemBindings := make(map[Expression]*matchBinding)
+			if !m.match(patternPair.val, items[i], itemBindings) {
+				return false
+			}
+			for v, b := range itemBindings {
+				seqs[v] = append(seqs[v], b)
+			}
+			formRest = formRest.(*SexpPair).next
+		}
+		for v, seq := range seqs {
+			bindings[v] = &matchBinding{seq: seq, isSeq: true}
+		}
+
+		return m.match(rest, formRest, bindings)
+	}
+
+	if formPair == EmptyList {
+		return false
+	}
+	return m.match(patternPair.val, formPair.val, bindings) && m.match(patternPair.next, formPair.next, bindings)
+}
+
+// patternVars lists the pattern variables in a pattern.
+func (m *Macro) patternVars(pattern Expression) (vars []Expression) {
+	switch p := pattern.(type) {
+	case Symbol, *RenamedSymbol:
+		if !m.isLiteral(p) && !m.isEllipsis(p) && baseSymbol(p) != "_" {
+			vars = append(vars, p)
+		}
+	case *SexpPair:
+		if p != EmptyList {
+			vars = append(m.patternVars(p.val), m.patternVars(p.next)...)
+		}
+	}
+	return
+}
+
+// templateName returns the name an element of a template goes by, whether it's code or quoted data.
+func templateName(e Expression) (Symbol, bool) {
+	if sym, ok := e.(QuotedSymbol); ok {
+		return Symbol(sym), true
+	}
+	return baseSymbol(e), isIdentifier(e)
+}
+
+// templateKey returns the key a template element would have in the bindings, if it were a pattern variable.
+// The reader makes symbols in quoted lists quoted symbols, so those are looked up by name.
+func templateKey(e Expression) Expression {
+	if sym, ok := e.(QuotedSymbol); ok {
+		return Symbol(sym)
+	}
+	return e
+}
+
+// templateVars lists the keys of anything in a template that could be a pattern variable.
+func templateVars(template Expression) (vars []Expression) {
+	switch t := template.(type) {
+	case Symbol, *RenamedSymbol, QuotedSymbol:
+		vars = append(vars, templateKey(t))
+	case *SexpPair:
+		if t != EmptyList {
+			vars = append(templateVars(t.val), templateVars(t.next)...)
+		}
+	}
+	return
+}
+
+// transcribe builds the expansion of a template, substituting what the pattern variables matched.
+// Within quoted lists, matched code is substituted as quoted data.
+func (m *Macro) transcribe(template Expression, bindings map[Expression]*matchBinding, renames map[Expression]*RenamedSymbol, ellipsis Symbol) (Expression, error) {
+	if _, named := templateName(template); named {
+		if binding, ok := bindings[templateKey(template)]; ok {
+			if binding.isSeq {
+				return nil, errorf(SyntaxError, "Bad syntax: pattern variable '%s' used without an ellipsis.", templateKey(template))
+			}
+			if _, quoted := template.(QuotedSymbol); quoted {
+				return quoteDatum(binding.expr), nil
+			}
+			return binding.expr, nil
+		}
+	}
+
+	if isIdentifier(template) {
+		// Introduced by the template, so it gets renamed; consistently, within one expansion
+		if renamed, ok := renames[template]; ok {
+			return renamed, nil
+		}
+		renamed := newRenamedSymbol(template, m)
+		renames[template] = renamed
+		return renamed, nil
+	}
+
+	pair, wasPair := template.(*SexpPair)
+	if !wasPair || pair == EmptyList {
+		return template, nil
+	}
+
+	// (... ...) escapes the ellipsis
+	if name, ok := templateName(pair.val); ok && name == ellipsis {
+		if next, ok := pair.next.(*SexpPair); ok && next != EmptyList {
+			return m.transcribe(next.val, bindings, renames, "")
+		}
+	}
+
+	dummy := &SexpPair{PTBlank, EmptyList, pair.literal, nil, nil}
+	tail := dummy
+	var rest Expression = pair
+	for {
+		current, ok := rest.(*SexpPair)
+		if !ok {
+			// Dotted tail
+			tailExpr, err := m.transcribe(rest, bindings, renames, ellipsis)
+			if err != nil {
+				return nil, err
+			}
+			tail.next = tailExpr
+			break
+		}
+		if current == EmptyList {
+			break
+		}
+
+		// Count the ellipses following this element
+		depth := 0
+		after := current.next
+		for next, ok := after.(*SexpPair); ok && next != EmptyList && ellipsis != ""; next, ok = after.(*SexpPair) {
+			if name, isName := templateName(next.val); !isName || name != ellipsis {
+				break
+			}
+			depth++
+			after = next.next
+		}
+
+		var items []Expression
+		if depth == 0 {
+			item, err := m.transcribe(current.val, bindings, renames, ellipsis)
+			if err != nil {
+				return nil, err
+			}
+			items = []Expression{item}
+		} else {
+			var err error
+			items, err = m.transcribeRepeated(current.val, depth, bindings, renames, ellipsis)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, item := range items {
+			nextPair := &SexpPair{item, EmptyList, pair.literal, nil, nil}
+			tail.next = nextPair
+			tail = nextPair
+		}
+		rest = after
+	}
+
+	return dummy.next, nil
+}
+
+// transcribeRepeated expands a subtemplate followed by depth ellipses, once for each repetition its pattern variables matched.
+func (m *Macro) transcribeRepeated(template Expression, depth int, bindings map[Expression]*matchBinding, renames map[Expression]*RenamedSymbol, ellipsis Symbol) ([]Expression, error) {
+	// Find the sequence variables this subtemplate iterates over
+	length := -1
+	var seqVars []Expression
+	for _, v := range templateVars(template) {
+		if binding, ok := bindings[v]; ok && binding.isSeq {
+			if length != -1 && len(binding.seq) != length {
+				return nil, errorf(SyntaxError, "Bad syntax: pattern variables under an ellipsis matched different numbers of times.")
+			}
+			length = len(binding.seq)
+			seqVars = append(seqVars, v)
+		}
+	}
+	if length == -1 {
+		return nil, errorf(SyntaxError, "Bad syntax: ellipsis in template follows no pattern variable with an ellipsis.")
+	}
+
+	var items []Expression
+	for i := 0; i < length; i++ {
+		itemBindings := make(map[Expression]*matchBinding, len(bindings))
+		for v, b := range bindings {
+			itemBindings[v] = b
+		}
+		for _, v := range seqVars {
+			itemBindings[v] = bindings[v].seq[i]
+		}
+
+		if depth > 1 {
+			nested, err := m.transcribeRepeated(template, depth-1, itemBindings, renames, ellipsis)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, nested...)
+		} else {
+			item, err := m.transcribe(template, itemBindings, renames, ellipsis)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+	}
+	return items, nil
+}
+
+func (m *Macro) GiveName(name string) {
+	if m.Name == "" {
+		m.Name = name
+	}
+}
+
+// displayName is what the macro is called in error messages.
+func (m *Macro) displayName() string {
+	if m.Name == "" {
+		return "macro"
+	}
+	return m.Name
+}
+
+func (m *Macro) String() string {
+	if m.Name != "" {
+		return fmt.Sprintf("#<macro:%s>", m.Name)
+	}
+
+	return "#<macro>"
+}
+
+func (m *Macro) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return m, env, nil
+}
+
+func (_ *Macro) IsLiteral() bool {
+	return true
+}
@@ -0,0 +1,244 @@
+package golftalk
+
+import (
+	"math/big"
+	"strconv"
+)
+
+// Helpers for the numeric tower: PTInt, PTBigInt, PTRational and PTFloat.
+// Exact operations are done on PTInts where possible, and only fall back to math/big when they would overflow or leave the integers.
+
+// The range of a PTInt, which is an int
+const (
+	maxInt = 1<<(strconv.IntSize-1) - 1
+	minInt = -1 << (strconv.IntSize - 1)
+)
+
+// normalizeBig demotes a big integer to a PTInt if it fits in one.
+func normalizeBig(i *big.Int) Expression {
+	if i.IsInt64() {
+		if small := i.Int64(); small >= minInt && small <= maxInt {
+			return PTInt(small)
+		}
+	}
+	return PTBigInt{i}
+}
+
+// toBig converts an integer expression to a big integer.
+func toBig(e Expression) (*big.Int, bool) {
+	switch n := e.(type) {
+	case PTInt:
+		return big.NewInt(int64(n)), true
+	case PTBigInt:
+		return n.Int, true
+	}
+	return nil, false
+}
+
+// normalizeRat demotes a rational to an integer if its denominator is 1.
+func normalizeRat(r *big.Rat) Expression {
+	if r.IsInt() {
+		return normalizeBig(new(big.Int).Set(r.Num()))
+	}
+	return PTRational{r}
+}
+
+// toRat converts an exact number to a big rational.
+func toRat(e Expression) (*big.Rat, bool) {
+	if r, ok := e.(PTRational); ok {
+		return r.Rat, true
+	}
+	if i, ok := toBig(e); ok {
+		return new(big.Rat).SetInt(i), true
+	}
+	return nil, false
+}
+
+// toFloat converts any number to a PTFloat.
+func toFloat(e Expression) (PTFloat, bool) {
+	switch n := e.(type) {
+	case PTInt:
+		return PTFloat(n), true
+	case PTBigInt:
+		f, _ := new(big.Float).SetInt(n.Int).Float64()
+		return PTFloat(f), true
+	case PTRational:
+		f, _ := n.Rat.Float64()
+		return PTFloat(f), true
+	case PTFloat:
+		return n, true
+	}
+	return 0, false
+}
+
+func isInteger(e Expression) bool {
+	_, ok := toBig(e)
+	return ok
+}
+
+func isExact(e Expression) bool {
+	_, ok := toRat(e)
+	return ok
+}
+
+func isNumber(e Expression) bool {
+	_, ok := toFloat(e)
+	return ok
+}
+
+// numOp describes a binary arithmetic operation at each level of the numeric tower.
+// small reports ok == false if the result can't be held by a PTInt.
+// big may be nil if integer operands don't always give an integer result, in which case they are handled as rationals.
+type numOp struct {
+	small func(a, b PTInt) (result PTInt, ok bool)
+	big   func(a, b *big.Int) *big.Int
+	rat   func(a, b *big.Rat) *big.Rat
+	float func(a, b PTFloat) PTFloat
+}
+
+// apply runs the operation on two numbers, using the simplest representation that holds the result exactly.
+// It returns false if either operand isn't a number.
+func (op numOp) apply(a, b Expression) (Expression, bool) {
+	if !isNumber(a) || !isNumber(b) {
+		return nil, false
+	}
+
+	// Floats are contagious
+	_, aFloat := a.(PTFloat)
+	_, bFloat := b.(PTFloat)
+	if aFloat || bFloat {
+		fa, _ := toFloat(a)
+		fb, _ := toFloat(b)
+		return op.float(fa, fb), true
+	}
+
+	ia, aSmall := a.(PTInt)
+	ib, bSmall := b.(PTInt)
+	if aSmall && bSmall {
+		if result, ok := op.small(ia, ib); ok {
+			return result, true
+		}
+	}
+
+	ba, aInt := toBig(a)
+	bb, bInt := toBig(b)
+	if aInt && bInt && op.big != nil {
+		return normalizeBig(op.big(ba, bb)), true
+	}
+
+	ra, _ := toRat(a)
+	rb, _ := toRat(b)
+	return normalizeRat(op.rat(ra, rb)), true
+}
+
+var addOp = numOp{
+	small: func(a, b PTInt) (PTInt, bool) {
+		sum := a + b
+		return sum, (sum > a) == (b > 0)
+	},
+	big: func(a, b *big.Int) *big.Int {
+		return new(big.Int).Add(a, b)
+	},
+	rat: func(a, b *big.Rat) *big.Rat {
+		return new(big.Rat).Add(a, b)
+	},
+	float: func(a, b PTFloat) PTFloat {
+		return a + b
+	},
+}
+
+var subtractOp = numOp{
+	small: func(a, b PTInt) (PTInt, bool) {
+		diff := a - b
+		return diff, (diff < a) == (b > 0)
+	},
+	big: func(a, b *big.Int) *big.Int {
+		return new(big.Int).Sub(a, b)
+	},
+	rat: func(a, b *big.Rat) *big.Rat {
+		return new(big.Rat).Sub(a, b)
+	},
+	float: func(a, b PTFloat) PTFloat {
+		return a - b
+	},
+}
+
+var multiplyOp = numOp{
+	small: func(a, b PTInt) (PTInt, bool) {
+		if a == 0 || b == 0 {
+			return 0, true
+		}
+		product := a * b
+		if product/b != a || (a == -1 && b == minInt) || (b == -1 && a == minInt) {
+			return 0, false
+		}
+		return product, true
+	},
+	big: func(a, b *big.Int) *big.Int {
+		return new(big.Int).Mul(a, b)
+	},
+	rat: func(a, b *big.Rat) *big.Rat {
+		return new(big.Rat).Mul(a, b)
+	},
+	float: func(a, b PTFloat) PTFloat {
+		return a * b
+	},
+}
+
+// Division is exact: integers that don't divide evenly give a rational.
+var divideOp = numOp{
+	small: func(a, b PTInt) (PTInt, bool) {
+		if (a == minInt && b == -1) || a%b != 0 {
+			return 0, false
+		}
+		return a / b, true
+	},
+	rat: func(a, b *big.Rat) *big.Rat {
+		return new(big.Rat).Quo(a, b)
+	},
+	float: func(a, b PTFloat) PTFloat {
+		return a / b
+	},
+}
+
+// isZero reports whether a number is zero.
+func isZero(e Expression) bool {
+	f, ok := toFloat(e)
+	return ok && f == 0
+}
+
+// compareNums returns -1, 0 or 1 as a is less than, equal to or greater than b.
+// Exact numbers are compared exactly; anything involving a float is compared as floats.
+func compareNums(a, b Expression) (int, bool) {
+	if !isNumber(a) || !isNumber(b) {
+		return 0, false
+	}
+
+	if ia, aSmall := a.(PTInt); aSmall {
+		if ib, bSmall := b.(PTInt); bSmall {
+			switch {
+			case ia < ib:
+				return -1, true
+			case ia > ib:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+
+	ra, aExact := toRat(a)
+	rb, bExact := toRat(b)
+	if aExact && bExact {
+		return ra.Cmp(rb), true
+	}
+
+	fa, _ := toFloat(a)
+	fb, _ := toFloat(b)
+	switch {
+	case fa < fb:
+		return -1, true
+	case fa > fb:
+		return 1, true
+	}
+	return 0, true
+}
@@ -1,9 +1,10 @@
-package main
+package golftalk
 
 import (
 	"bufio"
 	"fmt"
 	"io"
+	"math/big"
 	"strconv"
 	"strings"
 	"unicode"
@@ -13,10 +14,28 @@ type Scanner struct {
 	reader         io.Reader
 	bufferedReader *bufio.Reader
 	pos            int
+	line, column   int
+
+	// File names the source being read, for the positions of what's parsed from it
+	File string
+}
+
+// SourcePos is where something was read from, with lines and columns counted from 1.
+type SourcePos struct {
+	File   string
+	Line   int
+	Column int
+}
+
+func (p SourcePos) String() string {
+	if p.File == "" {
+		return fmt.Sprintf("%d:%d", p.Line, p.Column)
+	}
+	return fmt.Sprintf("%s:%d:%d", p.File, p.Line, p.Column)
 }
 
 func NewScanner(reader io.Reader) (scanner *Scanner) {
-	scanner = &Scanner{reader: reader}
+	scanner = &Scanner{reader: reader, line: 1, column: 1}
 	if buf, ok := reader.(*bufio.Reader); ok {
 		scanner.bufferedReader = buf
 	} else {
@@ -25,10 +44,26 @@ func NewScanner(reader io.Reader) (scanner *Scanner) {
 	return
 }
 
+// advance moves the scanner's position past a rune it has read.
+func (s *Scanner) advance(r rune) {
+	s.pos++
+	if r == '\n' {
+		s.line++
+		s.column = 1
+	} else {
+		s.column++
+	}
+}
+
+// Pos returns the position of the next rune to be read.
+func (s *Scanner) Pos() SourcePos {
+	return SourcePos{s.File, s.line, s.column}
+}
+
 func (s *Scanner) SkipSpace() {
 	reader := s.bufferedReader
 	for r, _, err := reader.ReadRune(); err == nil && unicode.IsSpace(r); r, _, err = reader.ReadRune() {
-		s.pos++
+		s.advance(r)
 	}
 	reader.UnreadRune()
 	return
@@ -43,16 +78,17 @@ func (s *Scanner) IsDone() bool {
 	return false
 }
 
-func (s *Scanner) Scan() (token string, pos int, err error) {
+func (s *Scanner) Scan() (token string, pos SourcePos, err error) {
 	reader := s.bufferedReader
 
 	s.SkipSpace()
-	pos = s.pos
+	pos = s.Pos()
 
 	first, _, err := reader.ReadRune()
 	if err != nil {
 		return
 	}
+	s.advance(first)
 	switch first {
 	case '(':
 		token = "("
@@ -63,74 +99,213 @@ func (s *Scanner) Scan() (token string, pos int, err error) {
 	case '\'':
 		token = "'"
 		return
+	case '`':
+		token = "`"
+		return
+	case ',':
+		token = ","
+		if next, _, err := reader.ReadRune(); err == nil {
+			if next == '@' {
+				token = ",@"
+				s.advance(next)
+			} else {
+				reader.UnreadRune()
+			}
+		}
+		return
+	case '"':
+		return s.scanString(pos)
 	}
 
 	var tok []rune
 	tok = append(tok, first)
-	s.pos++
 	for {
 		r, _, err := reader.ReadRune()
 		if err != nil {
 			break
 		}
-		if r == '(' || r == ')' || unicode.IsSpace(r) {
+		if r == '(' && first == '#' && len(tok) == 1 {
+			// Vector literal
+			tok = append(tok, r)
+			s.advance(r)
+			break
+		}
+		if r == '(' || r == ')' || r == '"' || unicode.IsSpace(r) {
 			reader.UnreadRune()
 			break
 		}
 		tok = append(tok, r)
-		s.pos++
+		s.advance(r)
 	}
 	token = string(tok)
-	pos = s.pos
 	return
 }
 
+var stringEscapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'a':  '\a',
+	'b':  '\b',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+}
+
+// stringEscapeNames maps the characters stringEscapes decodes back to their escapes.
+var stringEscapeNames = make(map[rune]rune, len(stringEscapes))
+
+func init() {
+	for name, r := range stringEscapes {
+		stringEscapeNames[r] = name
+	}
+}
+
+// scanString reads the rest of a string literal whose opening quote has already been read.
+// The returned token keeps its surrounding quotes, but has its escape sequences decoded.
+func (s *Scanner) scanString(start SourcePos) (token string, pos SourcePos, err error) {
+	reader := s.bufferedReader
+	pos = start
+
+	tok := []rune{'"'}
+	for {
+		runePos := s.Pos()
+		r, _, readErr := reader.ReadRune()
+		if readErr != nil {
+			return "", pos, ParseError{start, "unterminated string literal"}
+		}
+		s.advance(r)
+
+		switch r {
+		case '"':
+			return string(append(tok, '"')), pos, nil
+		case '\\':
+			esc, _, readErr := reader.ReadRune()
+			if readErr != nil {
+				return "", pos, ParseError{start, "unterminated string literal"}
+			}
+			s.advance(esc)
+
+			if esc == 'x' {
+				// Hex escapes look like \x41;
+				var hex []rune
+				for esc, _, readErr = reader.ReadRune(); readErr == nil && esc != ';'; esc, _, readErr = reader.ReadRune() {
+					hex = append(hex, esc)
+					s.advance(esc)
+				}
+				code, convErr := strconv.ParseUint(string(hex), 16, 32)
+				if readErr != nil || convErr != nil {
+					return "", pos, ParseError{runePos, "invalid hex escape in string literal"}
+				}
+				s.advance(esc)
+				tok = append(tok, rune(code))
+				continue
+			}
+
+			decoded, ok := stringEscapes[esc]
+			if !ok {
+				return "", pos, ParseError{runePos, fmt.Sprintf("unknown escape sequence \"\\%c\" in string literal", esc)}
+			}
+			tok = append(tok, decoded)
+		default:
+			tok = append(tok, r)
+		}
+	}
+}
+
 type ParseError struct {
-	pos    int
+	pos    SourcePos
 	reason string
 }
 
 func (e ParseError) Error() string {
-	return fmt.Sprintf("parse error: pos %d: %s", e.pos, e.reason)
+	return fmt.Sprintf("parse error: %s: %s", e.pos, e.reason)
 }
 
-func parseElement(scanner *Scanner, literal bool, inQuotedList bool, topLevel bool) (result Expression, err error) {
+// parseElement parses the next element, and also returns the position it starts at.
+func parseElement(scanner *Scanner, literal bool, inQuotedList bool, topLevel bool) (result Expression, pos SourcePos, err error) {
 	token, pos, err := scanner.Scan()
 	if err == io.EOF && !topLevel {
-		return nil, ParseError{pos, "expecting \")\""}
+		return nil, pos, ParseError{pos, "expecting \")\""}
 	}
 	if err != nil {
-		return nil, err
+		return nil, pos, err
 	}
 	switch token {
 	case ")":
 		if topLevel {
-			return PTBlank, ParseError{pos, "unexpected \")\""}
+			return PTBlank, pos, ParseError{pos, "unexpected \")\""}
+		}
+		return Symbol(")"), pos, nil
+	case ".":
+		if topLevel {
+			return PTBlank, pos, ParseError{pos, "unexpected \".\""}
 		}
-		return Symbol(")"), nil
+		return Symbol("."), pos, nil
 	case "(":
-		return parseList(scanner, literal || inQuotedList, false)
+		list, err := parseList(scanner, literal || inQuotedList, false, pos)
+		return list, pos, err
+	case "#(":
+		// Vectors are constants, so their elements are data like a quoted list's
+		items, err := parseList(scanner, true, false, pos)
+		if err != nil {
+			return nil, pos, err
+		}
+		slice, listErr := ToSlice(items)
+		if listErr != nil {
+			return nil, pos, ParseError{pos, "vector literal may not be a dotted list"}
+		}
+		return &PTVector{slice, true}, pos, nil
 	case "'":
 		if literal {
-			return nil, ParseError{pos, "unexpected quote in quoted expression"}
+			return nil, pos, ParseError{pos, "unexpected quote in quoted expression"}
 		}
-		result, err = parseElement(scanner, true, inQuotedList, topLevel)
+		result, _, err = parseElement(scanner, true, inQuotedList, topLevel)
 		if err != nil {
 			err = ParseError{pos, "expected something to quote"}
 		}
 		return
+	case "`", ",", ",@":
+		elem, elemPos, elemErr := parseElement(scanner, false, literal || inQuotedList, topLevel)
+		if elemErr != nil {
+			return nil, pos, ParseError{pos, fmt.Sprintf("expected something after \"%s\"", token)}
+		}
+		return readerAbbreviation(readerAbbreviations[token], elem, literal || inQuotedList, pos, elemPos), pos, nil
 	default:
-		if literal {
-			return QuotedSymbol(token), nil
+		if strings.HasPrefix(token, "\"") {
+			return PTString(token[1 : len(token)-1]), pos, nil
 		}
-		return Atomize(token), nil
+		atom := Atomize(token)
+		if sym, wasSym := atom.(Symbol); wasSym && (literal || inQuotedList) {
+			// Quoted symbols are data, and must not be looked up when they're handed around later
+			return QuotedSymbol(sym), pos, nil
+		}
+		return atom, pos, nil
+	}
+}
+
+var readerAbbreviations = map[string]Symbol{
+	"`":  "quasiquote",
+	",":  "unquote",
+	",@": "unquote-splicing",
+}
+
+// readerAbbreviation expands something like `x into (quasiquote x).
+// In quoted data, the expansion is quoted too.
+func readerAbbreviation(name Symbol, elem Expression, quoted bool, pos SourcePos, elemPos SourcePos) Expression {
+	var head Expression = name
+	if quoted {
+		head = QuotedSymbol(name)
 	}
+	return &SexpPair{head, &SexpPair{elem, EmptyList, quoted, &elemPos, nil}, quoted, &pos, &pos}
 }
 
-func parseList(scanner *Scanner, quoted bool, topLevel bool) (list *SexpPair, err error) {
-	dummy := &SexpPair{PTBlank, EmptyList, quoted}
+// parseList parses the elements of a list up to its closing parenthesis.
+// Each pair records the position of its element, and the first also records where the list opened.
+func parseList(scanner *Scanner, quoted bool, topLevel bool, open SourcePos) (list *SexpPair, err error) {
+	dummy := &SexpPair{PTBlank, EmptyList, quoted, nil, nil}
 	tail := dummy
-	for element, err := parseElement(scanner, false, quoted, topLevel); element != Symbol(")"); element, err = parseElement(scanner, false, quoted, topLevel) {
+	for element, pos, err := parseElement(scanner, false, quoted, topLevel); element != Symbol(")"); element, pos, err = parseElement(scanner, false, quoted, topLevel) {
 
 		if err == io.EOF && topLevel {
 			return dummy.next.(*SexpPair), nil
@@ -139,7 +314,32 @@ func parseList(scanner *Scanner, quoted bool, topLevel bool) (list *SexpPair, er
 			return dummy.next.(*SexpPair), err
 		}
 
-		nextPair := &SexpPair{element, EmptyList, quoted}
+		if element == Symbol(".") {
+			// Dotted pair: exactly one element may follow, and it becomes the tail
+			if tail == dummy {
+				return dummy.next.(*SexpPair), ParseError{pos, "expected an element before \".\""}
+			}
+			element, pos, err = parseElement(scanner, false, quoted, topLevel)
+			if err != nil {
+				return dummy.next.(*SexpPair), err
+			}
+			if element == Symbol(")") || element == Symbol(".") {
+				return dummy.next.(*SexpPair), ParseError{pos, "expected an element after \".\""}
+			}
+			tail.next = element
+			if closing, closingPos, err := parseElement(scanner, false, quoted, topLevel); err != nil {
+				return dummy.next.(*SexpPair), err
+			} else if closing != Symbol(")") {
+				return dummy.next.(*SexpPair), ParseError{closingPos, "expected \")\" after dotted pair tail"}
+			}
+			return dummy.next.(*SexpPair), nil
+		}
+
+		elemPos := pos
+		nextPair := &SexpPair{element, EmptyList, quoted, &elemPos, nil}
+		if tail == dummy && !topLevel {
+			nextPair.open = &open
+		}
 		tail.next = nextPair
 		tail = nextPair
 	}
@@ -147,9 +347,11 @@ func parseList(scanner *Scanner, quoted bool, topLevel bool) (list *SexpPair, er
 }
 
 func Parse(scanner *Scanner) (sexps []Expression, err error) {
-	sexp, err := parseList(scanner, false, true)
-	sexps = ToSlice(sexp)
-	return
+	sexp, err := parseList(scanner, false, true, scanner.Pos())
+	if err != nil {
+		return
+	}
+	return ToSlice(sexp)
 }
 
 func ParseLine(line string) (sexps []Expression, err error) {
@@ -161,8 +363,20 @@ func ParseLine(line string) (sexps []Expression, err error) {
 // If it fails to safely convert the string, it simply returns it as a string again.
 func Atomize(str string) Expression {
 	// First, try to Atomize it as an integer
-	if i, err := strconv.ParseInt(str, 10, 32); err == nil {
+	if i, err := strconv.ParseInt(str, 10, strconv.IntSize); err == nil {
 		return PTInt(int(i))
+	} else if err.(*strconv.NumError).Err == strconv.ErrRange {
+		// Too big for a PTInt, but still an integer
+		if b, ok := new(big.Int).SetString(str, 10); ok {
+			return PTBigInt{b}
+		}
+	}
+
+	// Maybe it's an exact fraction, like 1/3
+	if strings.Contains(str, "/") {
+		if r, ok := new(big.Rat).SetString(str); ok {
+			return normalizeRat(r)
+		}
 	}
 
 	// That didn't work? Maybe it's a float
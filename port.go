@@ -0,0 +1,120 @@
+package golftalk
+
+import (
+	"io"
+	"strings"
+)
+
+// PTPort is an output port, which text can be written to.
+type PTPort struct {
+	Writer io.Writer
+}
+
+// NewStringPort returns a port which collects what's written to it in a string.
+func NewStringPort() *PTPort {
+	return &PTPort{&strings.Builder{}}
+}
+
+func (p *PTPort) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return p, env, nil
+}
+
+func (p *PTPort) String() string {
+	if _, ok := p.Writer.(*strings.Builder); ok {
+		return "#<string-output-port>"
+	}
+	return "#<output-port>"
+}
+
+func (_ *PTPort) IsLiteral() bool {
+	return true
+}
+
+// outputPorts are an interpreter's ports: where output goes, and where traced procedures report their calls.
+type outputPorts struct {
+	Output *PTPort
+	Trace  *PTPort
+}
+
+// outputPorts finds the ports of the global Env at the end of the scope chain.
+func (e *Env) outputPorts() *outputPorts {
+	for ; e != nil; e = e.Outer {
+		if e.ports != nil {
+			return e.ports
+		}
+	}
+	return nil
+}
+
+func (p *outputPorts) currentOutputPort(args ...Expression) (Expression, error) {
+	if len(args) != 0 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting no arguments.")
+	}
+	return p.Output, nil
+}
+
+// traceOutputPort returns the port traced procedures write to, or with an argument, sets it.
+func (p *outputPorts) traceOutputPort(args ...Expression) (Expression, error) {
+	switch len(args) {
+	case 0:
+		return p.Trace, nil
+	case 1:
+		port, ok := args[0].(*PTPort)
+		if !ok {
+			return nil, errorf(TypeError, "Invalid type. Expecting a port.")
+		}
+		p.Trace = port
+		return PTBlank, nil
+	}
+	return nil, errorf(ArityError, "Invalid arguments. Expecting an optional port.")
+}
+
+// portArg returns the port given as the optional argument of an output procedure, or the current output port.
+func (p *outputPorts) portArg(args []Expression) (*PTPort, error) {
+	if len(args) == 0 {
+		return p.Output, nil
+	}
+	port, ok := args[0].(*PTPort)
+	if !ok {
+		return nil, errorf(TypeError, "Invalid type. Expecting a port.")
+	}
+	return port, nil
+}
+
+// writeTo writes text to the port an output procedure was given after its object, if any.
+func (p *outputPorts) writeTo(args []Expression, text string) (Expression, error) {
+	port, err := p.portArg(args)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(port.Writer, text); err != nil {
+		return nil, errorf(ValueError, "Couldn't write to %s: %s", port, err)
+	}
+	return PTBlank, nil
+}
+
+// display writes an object for people to read: a string is written as its bare contents.
+func (p *outputPorts) display(args ...Expression) (Expression, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting an object and an optional port.")
+	}
+	if s, ok := args[0].(PTString); ok {
+		return p.writeTo(args[1:], string(s))
+	}
+	return p.writeTo(args[1:], SexpToString(args[0]))
+}
+
+// write writes an object the way the reader would read it back.
+func (p *outputPorts) write(args ...Expression) (Expression, error) {
+	if len(args) != 1 && len(args) != 2 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting an object and an optional port.")
+	}
+	return p.writeTo(args[1:], SexpToString(args[0]))
+}
+
+func (p *outputPorts) newline(args ...Expression) (Expression, error) {
+	if len(args) > 1 {
+		return nil, errorf(ArityError, "Invalid arguments. Expecting an optional port.")
+	}
+	return p.writeTo(args, "\n")
+}
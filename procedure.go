@@ -1,4 +1,4 @@
-package main
+package golftalk
 
 import (
 	"fmt"
@@ -6,7 +6,7 @@ import (
 
 type Procedure interface {
 	//Runs/resumes the procedure
-	Run(frame *StackFrame, stack *Stack) (result Expression, newEnv *Env, err string)
+	Run(frame *StackFrame, stack *Stack) (result Expression, newEnv *Env, err error)
 
 	//Sets the name of the Procedure if it doesn't have one already
 	GiveName(name string)
@@ -16,49 +16,84 @@ type Procedure interface {
 
 //TODO: decide if this should be called PTProc or something
 type Proc struct {
-	Name    string
-	Vars    []Symbol
-	Exp     Expression
-	EvalEnv *Env
+	Name     string
+	Vars     []Symbol
+	Optional []OptionalParam
+	Rest     Symbol // "" if the procedure takes no rest parameter
+	Exp      Expression
+	EvalEnv  *Env
+}
+
+// OptionalParam is a parameter which may be left out of a call.
+// If it is, its Default is evaluated with the parameters before it bound; without a Default, it's bound to #f.
+type OptionalParam struct {
+	Name    Symbol
+	Default Expression
 }
 
 var _ Procedure = &Proc{}
 
-func (p *Proc) Run(frame *StackFrame, stack *Stack) (result Expression, newEnv *Env, err string) {
-	args, env := frame.Args, frame.CurrentEnv
-	frame.Step++
-	curVar := frame.Step - 1 // Starts at 0
-	if frame.Step == 1 {
-		// Set up a new environment to do bindings in
-		frame.CurrentEnv = NewEnv()
-		frame.CurrentEnv.Outer = env
-		env = frame.CurrentEnv
-	} else {
-		// Bind the last evaluation to the last var
-		env.Dict[p.Vars[curVar-1]] = frame.StepInput
-	}
-	if curVar < len(p.Vars) {
-		//Get next argument to bind
-		bindingExpr := args.val
-		var argsOk bool
-		frame.Args, argsOk = args.next.(*SexpPair)
-		if !argsOk {
-			return nil, nil, "Invalid argument list"
+// Accepts reports whether the procedure can be called with the given number of arguments.
+func (p *Proc) Accepts(numArgs int) bool {
+	return numArgs >= len(p.Vars) && (p.Rest != "" || numArgs <= len(p.Vars)+len(p.Optional))
+}
+
+// bind makes the Env for a call, beneath the one the procedure was defined in.
+// Any arguments past the required and optional parameters are gathered into the rest parameter.
+func (p *Proc) bind(vals []Expression) *Env {
+	env := MakeEnv(p.Vars, vals, p.EvalEnv)
+	env.Proc = p
+
+	for i, opt := range p.Optional {
+		if len(p.Vars)+i < len(vals) {
+			env.Dict[opt.Name] = vals[len(p.Vars)+i]
+		}
+	}
+
+	if p.Rest != "" {
+		env.Dict[p.Rest] = EmptyList
+		if numFixed := len(p.Vars) + len(p.Optional); len(vals) > numFixed {
+			env.Dict[p.Rest] = toList(vals[numFixed:]...)
 		}
+	}
+
+	return env
+}
+
+func (p *Proc) Run(frame *StackFrame, stack *Stack) (result Expression, newEnv *Env, err error) {
+	frame.Step++
 
-		// Evaluate the value of the binding in the outer Env!
-		return bindingExpr, env.Outer, ""
+	// Evaluate the arguments in the caller's Env
+	next, done, err := frame.NextArg()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !done {
+		return next, frame.CurrentEnv, nil
 	}
 
-	if args != EmptyList {
-		return nil, nil, "Too many arguments"
+	if !p.Accepts(len(frame.EvaluatedArgs)) {
+		if len(frame.EvaluatedArgs) < len(p.Vars) {
+			return nil, nil, errorf(ArityError, "Too few arguments")
+		}
+		return nil, nil, errorf(ArityError, "Too many arguments")
+	}
+
+	// Fill in any optional parameters that were left out
+	for len(frame.EvaluatedArgs) < len(p.Vars)+len(p.Optional) {
+		opt := p.Optional[len(frame.EvaluatedArgs)-len(p.Vars)]
+		if opt.Default != nil {
+			// The default's value gets collected like an argument on the next step
+			return opt.Default, p.bind(frame.EvaluatedArgs), nil
+		}
+		frame.EvaluatedArgs = append(frame.EvaluatedArgs, PTBool(false))
 	}
 
 	// Don't need our frame anymore
 	stack.Pop()
 
-	// Set the expression to be evaluated
-	return p.Exp, env, ""
+	// Set the expression to be evaluated, with the arguments bound in a fresh Env
+	return p.Exp, p.bind(frame.EvaluatedArgs), nil
 }
 
 func (p *Proc) GiveName(name string) {
@@ -75,61 +110,98 @@ func (p *Proc) String() string {
 	return "#<procedure>"
 }
 
-func (p *Proc) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err string) {
-	return p, env, ""
+func (p *Proc) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return p, env, nil
 }
 
 func (_ *Proc) IsLiteral() bool {
 	return true
 }
 
-type goProcPtr func(args ...Expression) (Expression, string)
-
-type GoProc struct {
+// CaseLambda is a procedure which dispatches on the number of arguments it's called with, to the first clause that accepts them.
+type CaseLambda struct {
 	Name    string
-	funcPtr goProcPtr
+	Clauses []*Proc
 }
 
-func (g *GoProc) Run(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, err string) {
-	args, env := frame.Args, frame.CurrentEnv
+var _ Procedure = &CaseLambda{}
+
+func (c *CaseLambda) Run(frame *StackFrame, stack *Stack) (result Expression, newEnv *Env, err error) {
 	frame.Step++
 
-	if frame.Step == 1 {
-		//I need a new env for my local variables :(
-		frame.CurrentEnv = NewEnv()
-		frame.CurrentEnv.Outer = env
-		env = frame.CurrentEnv
-	} else {
-		entry := &SexpPair{frame.StepInput, EmptyList, false}
-		if tail, ok := env.Dict["__goproc_run_tail__"]; ok {
-			tail.(*SexpPair).next = entry
-		} else {
-			// If first entry, save it as the head, too
-			env.Dict["__goproc_run_head__"] = entry
+	next, done, err := frame.NextArg()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !done {
+		return next, frame.CurrentEnv, nil
+	}
+
+	for _, clause := range c.Clauses {
+		if clause.Accepts(len(frame.EvaluatedArgs)) {
+			// Hand the frame over to the clause as though it had just started; it will find its arguments already evaluated
+			frame.Running = clause
+			frame.Step = 0
+			return clause.Run(frame, stack)
 		}
-		env.Dict["__goproc_run_tail__"] = entry
 	}
 
-	if args != EmptyList {
-		bindingExpr := args.val
+	return nil, nil, errorf(ArityError, "No case-lambda clause accepts %d arguments.", len(frame.EvaluatedArgs))
+}
 
-		var argsOk bool
-		frame.Args, argsOk = args.next.(*SexpPair)
-		if !argsOk {
-			return nil, nil, "Invalid argument list"
-		}
+func (c *CaseLambda) GiveName(name string) {
+	if c.Name == "" {
+		c.Name = name
+	}
+	for _, clause := range c.Clauses {
+		clause.GiveName(name)
+	}
+}
 
-		// Evaluate the value of the binding in the outer Env!
-		return bindingExpr, env.Outer, ""
+func (c *CaseLambda) String() string {
+	if c.Name != "" {
+		return fmt.Sprintf("#<procedure:%s>", c.Name)
+	}
+
+	return "#<procedure>"
+}
+
+func (c *CaseLambda) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return c, env, nil
+}
+
+func (_ *CaseLambda) IsLiteral() bool {
+	return true
+}
+
+type goProcPtr func(args ...Expression) (Expression, error)
+
+type GoProc struct {
+	Name    string
+	funcPtr goProcPtr
+}
+
+func (g *GoProc) Run(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, err error) {
+	frame.Step++
+
+	// Evaluate the arguments in the caller's Env
+	next, done, err := frame.NextArg()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !done {
+		return next, frame.CurrentEnv, nil
+	}
+
+	result, err = g.funcPtr(frame.EvaluatedArgs...)
+	if err != nil {
+		// Leave our frame be, so it shows up in the traceback
+		return nil, nil, err
 	}
 
 	// All done, don't need our frame anymore
 	stack.Pop()
-
-	evaluatedArgs, _ := env.Dict["__goproc_run_head__"].(*SexpPair)
-	argSlice := ToSlice(evaluatedArgs)
-	result, err = g.funcPtr(argSlice...)
-	return result, env.Outer, err
+	return result, frame.CurrentEnv, nil
 }
 
 func (g *GoProc) GiveName(name string) {
@@ -146,18 +218,62 @@ func (g *GoProc) String() string {
 	return "#<procedure>"
 }
 
-func (g *GoProc) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err string) {
-	return g, env, ""
+func (g *GoProc) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return g, env, nil
 }
 
 func (_ *GoProc) IsLiteral() bool {
 	return true
 }
 
-func (f CoreFunc) Run(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, err string) {
+// Continuation is the escape procedure handed out by call/cc.
+// Invoking it throws away the current stack and resumes a copy of the captured one with the given value, so it may be invoked any number of times.
+type Continuation struct {
+	Frames Stack
+}
+
+var _ Procedure = &Continuation{}
+
+func (c *Continuation) Run(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, err error) {
+	frame.Step++
+
+	next, done, err := frame.NextArg()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !done {
+		return next, frame.CurrentEnv, nil
+	}
+
+	if len(frame.EvaluatedArgs) != 1 {
+		return nil, nil, errorf(ArityError, "Continuations take exactly one argument.")
+	}
+
+	// Hand the value to whatever was waiting on the captured stack
+	return stack.jump(c.Frames, frame.EvaluatedArgs[0], frame.CurrentEnv)
+}
+
+func (_ *Continuation) GiveName(name string) {
+	//dont care
+	return
+}
+
+func (_ *Continuation) String() string {
+	return "#<continuation>"
+}
+
+func (c *Continuation) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return c, env, nil
+}
+
+func (_ *Continuation) IsLiteral() bool {
+	return true
+}
+
+func (f CoreFunc) Run(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, err error) {
 	var done bool
 	result, nextEnv, done, err = f(frame, stack)
-	if done {
+	if done && err == nil {
 		stack.Pop()
 	}
 	return
@@ -172,8 +288,8 @@ func (_ CoreFunc) String() string {
 	return "#<core procedure>"
 }
 
-func (f CoreFunc) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err string) {
-	return f, env, ""
+func (f CoreFunc) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return f, env, nil
 }
 
 func (_ CoreFunc) IsLiteral() bool {
@@ -0,0 +1,391 @@
+package golftalk
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+)
+
+// topLevelName stands for evaluation outside of any procedure in profiles.
+const topLevelName = "top level"
+
+// Profiler measures what each procedure costs while evaluations run: how often it's called, and the steps and wall time spent in it.
+// Before each step it samples the Stack; each frame's Env says which procedure's body the frame belongs to, so procedures are seen even though tail calls leave no frame behind.
+// Each frame remembers where it is in the Profiler's tree of stacks, so a step only looks at the frames that are new since the last one.
+type Profiler struct {
+	samples map[string]*profileSample
+	root    *profileNode
+	start   time.Time
+	// env is the Env of the last step, and owner what envOwner made of it
+	env   *Env
+	owner string
+	// last is the sample the step in progress is charged to, and since is when that step began
+	last  *profileSample
+	since time.Time
+}
+
+// profileSample is what's been charged to one stack of procedures.
+type profileSample struct {
+	Stack []string // outermost first
+	Calls int64    // calls made to the innermost procedure from the rest of the stack
+	Steps int64
+	Wall  time.Duration
+}
+
+// profileNode is a stack of procedures, which extends its parent's by one.
+// Its sample is only made, and keyed, once something is charged to the stack.
+type profileNode struct {
+	profiler *Profiler
+	parent   *profileNode
+	name     string
+	children map[string]*profileNode
+	sample   *profileSample
+}
+
+// child is the stack with name pushed on top, or the same stack if recursion into name would be collapsed.
+func (n *profileNode) child(name string) *profileNode {
+	if n.name == name {
+		return n
+	}
+	c, ok := n.children[name]
+	if !ok {
+		c = &profileNode{profiler: n.profiler, parent: n, name: name, children: make(map[string]*profileNode)}
+		n.children[name] = c
+	}
+	return c
+}
+
+// record returns the sample charged for the stack, making it the first time.
+func (n *profileNode) record() *profileSample {
+	if n.sample == nil {
+		var names []string
+		for m := n; m != nil; m = m.parent {
+			names = append(names, m.name)
+		}
+		for i, j := 0, len(names)-1; i < j; i, j = i+1, j-1 {
+			names[i], names[j] = names[j], names[i]
+		}
+		n.sample = &profileSample{Stack: names}
+		n.profiler.samples[strings.Join(names, "\x00")] = n.sample
+	}
+	return n.sample
+}
+
+// ProfileEntry sums up one procedure's costs.
+// Inclusive costs count everything done while the procedure was on the stack, and exclusive costs only what was done in its own body.
+type ProfileEntry struct {
+	Name           string
+	Calls          int64
+	Steps          int64
+	ExclusiveSteps int64
+	Wall           time.Duration
+	ExclusiveWall  time.Duration
+}
+
+func NewProfiler() *Profiler {
+	p := &Profiler{samples: make(map[string]*profileSample), start: time.Now()}
+	p.root = &profileNode{profiler: p, name: topLevelName, children: make(map[string]*profileNode)}
+	return p
+}
+
+// Eval evaluates expr in env, charging its costs to the procedures it runs.
+func (p *Profiler) Eval(expr Expression, env *Env) (Expression, error) {
+	return evalWatched(expr, env, p)
+}
+
+func (p *Profiler) beforeStep(expr Expression, env *Env, stack Stack) error {
+	now := time.Now()
+	if p.last != nil {
+		p.last.Wall += now.Sub(p.since)
+	}
+
+	if env != p.env {
+		p.env, p.owner = env, envOwner(env)
+	}
+	node := p.frameNode(stack).child(p.owner)
+	if callee, ok := calledProcedure(expr, stack); ok {
+		node.child(callee).record().Calls++
+	}
+	if goProc, ok := runningGoProc(expr, stack); ok {
+		// The Go function runs during this step
+		node = node.child(goProc)
+	}
+
+	p.last, p.since = node.record(), now
+	p.last.Steps++
+	return nil
+}
+
+// frameNode finds the stack of procedures whose bodies the frames are in, outermost first, with recursion into the same procedure collapsed.
+// Frames below the top only change by being popped or replaced, so it works down only as far as the last frame that already knows its node.
+func (p *Profiler) frameNode(stack Stack) *profileNode {
+	known := func(f *StackFrame) bool {
+		return f.profile != nil && f.profile.profiler == p && f.profileEnv == f.CurrentEnv
+	}
+	i := len(stack)
+	for i > 0 && !known(&stack[i-1]) {
+		i--
+	}
+	node := p.root
+	if i > 0 {
+		node = stack[i-1].profile
+	}
+	for ; i < len(stack); i++ {
+		node = node.child(envOwner(stack[i].CurrentEnv))
+		stack[i].profile, stack[i].profileEnv = node, stack[i].CurrentEnv
+	}
+	return node
+}
+
+func (_ *Profiler) beforeExpand(form Expression, depth int) error {
+	return nil
+}
+
+func (p *Profiler) done() {
+	if p.last != nil {
+		p.last.Wall += time.Since(p.since)
+		p.last = nil
+	}
+}
+
+// envOwner names the procedure whose body an Env is for.
+// Envs made by let and the like belong to the procedure they're inside.
+func envOwner(env *Env) string {
+	for ; env != nil; env = env.Outer {
+		if env.Proc != nil {
+			return profileName(env.Proc)
+		}
+	}
+	return topLevelName
+}
+
+// calledProcedure reports whether a procedure is being handed to the frame that will run it, which is when calls are counted.
+func calledProcedure(expr Expression, stack Stack) (name string, ok bool) {
+	if len(stack) == 0 || !expr.IsLiteral() {
+		return "", false
+	}
+	top := stack[len(stack)-1]
+	if top.Running != nil || top.Step != -1 {
+		return "", false
+	}
+	switch expr.(type) {
+	case *Proc, *GoProc, *CaseLambda, *Traced:
+		return profileName(expr.(Procedure)), true
+	}
+	return "", false
+}
+
+// runningGoProc reports whether the top frame will run its Go function this step, having been handed its last argument.
+func runningGoProc(expr Expression, stack Stack) (name string, ok bool) {
+	if len(stack) == 0 || !expr.IsLiteral() {
+		return "", false
+	}
+	top := stack[len(stack)-1]
+	proc := top.Running
+	if proc == nil {
+		proc, _ = expr.(Procedure)
+	}
+	if goProc, isGoProc := proc.(*GoProc); isGoProc && top.Args == EmptyList {
+		return profileName(goProc), true
+	}
+	return "", false
+}
+
+// profileName names a procedure in profiles; anonymous ones are told apart by where they were written, if that's known.
+func profileName(proc Procedure) string {
+	switch p := proc.(type) {
+	case *Proc:
+		if p.Name != "" {
+			return p.Name
+		}
+		if pos := sourcePos(p.Exp, nil); pos != nil {
+			return fmt.Sprintf("lambda at %s", pos)
+		}
+		return "lambda"
+	case *GoProc:
+		return p.Name
+	case *CaseLambda:
+		return p.Name
+	case *Traced:
+		return profileName(p.Proc)
+	}
+	return proc.String()
+}
+
+// Entries sums up the costs of each procedure seen, costliest first.
+func (p *Profiler) Entries() []ProfileEntry {
+	byName := make(map[string]*ProfileEntry)
+	entry := func(name string) *ProfileEntry {
+		if byName[name] == nil {
+			byName[name] = &ProfileEntry{Name: name}
+		}
+		return byName[name]
+	}
+
+	for _, s := range p.samples {
+		leaf := entry(s.Stack[len(s.Stack)-1])
+		leaf.Calls += s.Calls
+		leaf.ExclusiveSteps += s.Steps
+		leaf.ExclusiveWall += s.Wall
+
+		// Recursion can put a procedure on the stack more than once, but it's only charged once
+		seen := make(map[string]bool)
+		for _, name := range s.Stack {
+			if !seen[name] {
+				seen[name] = true
+				entry(name).Steps += s.Steps
+				entry(name).Wall += s.Wall
+			}
+		}
+	}
+
+	entries := make([]ProfileEntry, 0, len(byName))
+	for _, e := range byName {
+		entries = append(entries, *e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Steps != entries[j].Steps {
+			return entries[i].Steps > entries[j].Steps
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	return entries
+}
+
+// Report writes a table of each procedure's costs.
+func (p *Profiler) Report(w io.Writer) {
+	fmt.Fprintf(w, "%8s %10s %10s %12s %12s  %s\n", "calls", "steps", "self steps", "time", "self time", "procedure")
+	for _, e := range p.Entries() {
+		fmt.Fprintf(w, "%8d %10d %10d %12s %12s  %s\n", e.Calls, e.Steps, e.ExclusiveSteps, e.Wall.Round(time.Microsecond), e.ExclusiveWall.Round(time.Microsecond), e.Name)
+	}
+}
+
+// WritePprof writes the samples as a gzipped profile in the format read by go tool pprof, with golftalk procedures standing in for functions.
+func (p *Profiler) WritePprof(w io.Writer) error {
+	strs := map[string]uint64{}
+	var table []string
+	str := func(s string) uint64 {
+		if i, ok := strs[s]; ok {
+			return i
+		}
+		strs[s] = uint64(len(table))
+		table = append(table, s)
+		return strs[s]
+	}
+	str("")
+
+	var prof protoBuffer
+	valueType := func(tag int, typ, unit string) {
+		prof.message(tag, func(b *protoBuffer) {
+			b.uint64Field(1, str(typ))
+			b.uint64Field(2, str(unit))
+		})
+	}
+	valueType(1, "calls", "count")
+	valueType(1, "steps", "count")
+	valueType(1, "wall", "nanoseconds")
+	valueType(11, "steps", "count")
+	prof.uint64Field(12, 1)
+	prof.uint64Field(14, str("steps"))
+
+	// Each procedure gets a function, and a location to go with it
+	funcIDs := map[string]uint64{}
+	var funcNames []string
+	funcID := func(name string) uint64 {
+		if id, ok := funcIDs[name]; ok {
+			return id
+		}
+		funcIDs[name] = uint64(len(funcNames) + 1)
+		funcNames = append(funcNames, name)
+		return funcIDs[name]
+	}
+
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		s := p.samples[key]
+		var locations []uint64
+		for i := len(s.Stack) - 1; i >= 0; i-- {
+			// pprof wants the innermost location first
+			locations = append(locations, funcID(s.Stack[i]))
+		}
+		prof.message(2, func(b *protoBuffer) {
+			b.packedField(1, locations)
+			b.packedField(2, []uint64{uint64(s.Calls), uint64(s.Steps), uint64(s.Wall.Nanoseconds())})
+		})
+	}
+
+	for i, name := range funcNames {
+		id := uint64(i + 1)
+		prof.message(4, func(b *protoBuffer) {
+			b.uint64Field(1, id)
+			b.message(4, func(line *protoBuffer) {
+				line.uint64Field(1, id)
+			})
+		})
+		prof.message(5, func(b *protoBuffer) {
+			b.uint64Field(1, id)
+			b.uint64Field(2, str(name))
+			b.uint64Field(3, str(name))
+		})
+	}
+
+	for _, s := range table {
+		prof.bytesField(6, []byte(s))
+	}
+	prof.uint64Field(9, uint64(p.start.UnixNano()))
+	prof.uint64Field(10, uint64(time.Since(p.start).Nanoseconds()))
+
+	gz := gzip.NewWriter(w)
+	if _, err := gz.Write(prof.data); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// protoBuffer encodes the few protocol buffer wire types a profile needs.
+type protoBuffer struct {
+	data []byte
+}
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) uint64Field(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.varint(uint64(tag) << 3)
+	b.varint(x)
+}
+
+func (b *protoBuffer) bytesField(tag int, data []byte) {
+	b.varint(uint64(tag)<<3 | 2)
+	b.varint(uint64(len(data)))
+	b.data = append(b.data, data...)
+}
+
+func (b *protoBuffer) packedField(tag int, xs []uint64) {
+	var packed protoBuffer
+	for _, x := range xs {
+		packed.varint(x)
+	}
+	b.bytesField(tag, packed.data)
+}
+
+func (b *protoBuffer) message(tag int, encode func(*protoBuffer)) {
+	var msg protoBuffer
+	encode(&msg)
+	b.bytesField(tag, msg.data)
+}
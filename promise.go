@@ -0,0 +1,27 @@
+package golftalk
+
+// Promise is a value whose computation is put off until it's forced, and then remembered.
+// Promises made by delay-force hand over to the promise their expression yields, and share its state from then on, so chains of them are forced in constant space.
+type Promise struct {
+	state *promiseState
+}
+
+type promiseState struct {
+	Done  bool
+	Value Expression
+	Exp   Expression
+	Env   *Env
+	Lazy  bool // Exp yields another promise to force in this one's place, as with delay-force
+}
+
+func (p *Promise) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return p, env, nil
+}
+
+func (p *Promise) String() string {
+	return "#<promise>"
+}
+
+func (_ *Promise) IsLiteral() bool {
+	return true
+}
@@ -0,0 +1,120 @@
+package golftalk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// RecordType describes the records made by a define-record-type.
+type RecordType struct {
+	Name   string
+	Fields []Symbol
+}
+
+func (r *RecordType) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return r, env, nil
+}
+
+func (r *RecordType) String() string {
+	return fmt.Sprintf("#<record-type %s>", r.Name)
+}
+
+func (_ *RecordType) IsLiteral() bool {
+	return true
+}
+
+// fieldIndex returns the position of a field among the type's fields, or -1 if there's no such field.
+func (r *RecordType) fieldIndex(field Symbol) int {
+	for i, f := range r.Fields {
+		if f == field {
+			return i
+		}
+	}
+	return -1
+}
+
+// constructor makes the procedure which makes a record from values for the given fields, leaving the rest #f.
+func (r *RecordType) constructor(name string, fields []int) goProcPtr {
+	return func(args ...Expression) (Expression, error) {
+		if len(args) != len(fields) {
+			return nil, errorf(ArityError, "Invalid arguments. %s expects exactly %d arguments.", name, len(fields))
+		}
+		record := &PTRecord{r, make([]Expression, len(r.Fields))}
+		for i := range record.Values {
+			record.Values[i] = PTBool(false)
+		}
+		for i, field := range fields {
+			record.Values[field] = args[i]
+		}
+		return record, nil
+	}
+}
+
+func (r *RecordType) predicate() goProcPtr {
+	return func(args ...Expression) (Expression, error) {
+		if len(args) != 1 {
+			return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+		}
+		record, ok := args[0].(*PTRecord)
+		return PTBool(ok && record.Type == r), nil
+	}
+}
+
+// record checks that an argument given to the named procedure is a record of this type.
+func (r *RecordType) record(name string, arg Expression) (*PTRecord, error) {
+	record, ok := arg.(*PTRecord)
+	if !ok || record.Type != r {
+		return nil, errorf(TypeError, "Invalid type. %s needs a %s record.", name, r.Name)
+	}
+	return record, nil
+}
+
+func (r *RecordType) accessor(name string, field int) goProcPtr {
+	return func(args ...Expression) (Expression, error) {
+		if len(args) != 1 {
+			return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 1 argument.")
+		}
+		record, err := r.record(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		return record.Values[field], nil
+	}
+}
+
+func (r *RecordType) modifier(name string, field int) goProcPtr {
+	return func(args ...Expression) (Expression, error) {
+		if len(args) != 2 {
+			return nil, errorf(ArityError, "Invalid arguments. Expecting exactly 2 arguments.")
+		}
+		record, err := r.record(name, args[0])
+		if err != nil {
+			return nil, err
+		}
+		record.Values[field] = args[1]
+		return PTBlank, nil
+	}
+}
+
+// PTRecord is an instance of a record type, holding a value for each of its fields.
+// Records can be changed in place, so they're always handled by pointer.
+type PTRecord struct {
+	Type   *RecordType
+	Values []Expression
+}
+
+func (r *PTRecord) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return r, env, nil
+}
+
+func (r *PTRecord) String() string {
+	parts := []string{"#<record", r.Type.Name}
+	for i, field := range r.Type.Fields {
+		parts = append(parts, fmt.Sprintf("%s: %s", field, elementToString(r.Values[i])))
+	}
+	return strings.Join(parts, " ") + ">"
+}
+
+func (_ *PTRecord) IsLiteral() bool {
+	return true
+}
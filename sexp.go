@@ -1,4 +1,4 @@
-package main
+package golftalk
 
 import (
 	"errors"
@@ -8,6 +8,8 @@ type SexpPair struct {
 	val     Expression
 	next    Expression
 	literal bool
+	pos     *SourcePos // where the pair's element was read from, if it was parsed
+	open    *SourcePos // where the list was opened, if this pair starts a parsed list
 }
 
 var EmptyList *SexpPair = nil
@@ -31,7 +33,16 @@ func toList(items ...Expression) (head *SexpPair) {
 	head = EmptyList
 	for i := len(items) - 1; i >= 0; i-- {
 		// TODO: Figure out if this should create literal lists or not
-		head = &SexpPair{items[i], head, true}
+		head = &SexpPair{items[i], head, true, nil, nil}
+	}
+	return
+}
+
+// toCode builds a list that will be evaluated as code, unlike toList.
+func toCode(items ...Expression) (head *SexpPair) {
+	head = EmptyList
+	for i := len(items) - 1; i >= 0; i-- {
+		head = &SexpPair{items[i], head, false, nil, nil}
 	}
 	return
 }
@@ -48,9 +59,12 @@ func Get(lst *SexpPair, n int) Expression {
 }
 
 // ToSlice converts a linked list into a slice.
-func ToSlice(lst *SexpPair) (result []Expression) {
-	//FIXME: should probably be able to return an error if this errors
-	count, _ := lst.Len()
+// It returns an error if given an improper list.
+func ToSlice(lst *SexpPair) (result []Expression, err error) {
+	count, err := lst.Len()
+	if err != nil {
+		return nil, err
+	}
 	result = make([]Expression, 0, count)
 
 	ok := true
@@ -75,63 +89,107 @@ func SetIsLiteral(lst *SexpPair, l bool) {
 }
 
 var coreFuncs = map[Symbol]CoreFunc{
-	"call/cc": coreCallCC,
+	"call/cc":                        coreCallCC,
+	"call-with-current-continuation": coreCallCC,
 
 	"yknow":  coreDefine,
 	"define": coreDefine,
 
+	"set!": coreSet,
+
 	"insofaras": coreIf,
 	"if":        coreIf,
 
 	"bring-me-back-something-good": coreLambda,
 	"lambda":                       coreLambda,
 
+	"case-lambda": coreCaseLambda,
+
 	"this-guy": coreQuote,
 	"quote":    coreQuote,
 
+	"quasiquote":       coreQuasiquote,
+	"unquote":          coreUnquote,
+	"unquote-splicing": coreUnquote,
+
 	"crunch-crunch-crunch": coreApply,
 	"apply":                coreApply,
 
-	"let": coreLet,
+	"let":     coreLet,
+	"let*":    coreLetStar,
+	"letrec":  coreLetrec,
+	"letrec*": coreLetrecStar,
 
 	"cond": coreCond,
 
 	"begin": coreBegin,
 
+	"with-exception-handler": coreWithExceptionHandler,
+	"guard":                  coreGuard,
+	"dynamic-wind":           coreDynamicWind,
+	"call-with-values":       coreCallWithValues,
+	"let-values":             coreLetValues,
+	"trace":                  coreTrace,
+	"untrace":                coreUntrace,
+	"define-record-type":     coreDefineRecordType,
+	"delay":                  coreDelay,
+	"delay-force":            coreDelayForce,
+	"force":                  coreForce,
+
+	"define-syntax": syntaxOnly("define-syntax"),
+	"let-syntax":    syntaxOnly("let-syntax"),
+	"letrec-syntax": syntaxOnly("letrec-syntax"),
+	"syntax-rules":  syntaxOnly("syntax-rules"),
+
 	"exit": haveANiceDay,
 }
 
-func (lst *SexpPair) Eval(stack *Stack, env *Env) (result Expression, nextEnv *Env, err string) {
+func (lst *SexpPair) Eval(stack *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
 	// Is the sexp literal?
 	// If so, just return it.
 	if lst == EmptyList || lst.literal {
-		return lst, env, ""
+		return lst, env, nil
 	}
 
 	procExpr := lst.val
 
 	// Validate argument list
-	args, argsOk := lst.next.(*SexpPair)
-	if !argsOk {
-		return nil, nil, "Function has invalid argument list."
+	if _, argsOk := lst.next.(*SexpPair); !argsOk {
+		return nil, nil, errorf(SyntaxError, "Function has invalid argument list.")
 	}
 
-	stack.Push(args, env)
+	stack.Push(lst, env)
 	//Evaluate expression to run (will get put in frame.Running in step -1)
-	return procExpr, env, ""
+	return procExpr, env, nil
 }
 
 func (l *SexpPair) String() (ret string) {
 	ret = "("
-	for ok := true; ok && l != EmptyList; l, ok = l.next.(*SexpPair) {
-		ret = ret + SexpToString(l.val)
-		if next, nextOk := l.next.(*SexpPair); nextOk && next != EmptyList {
+	for l != EmptyList {
+		ret = ret + elementToString(l.val)
+
+		next, nextOk := l.next.(*SexpPair)
+		if !nextOk {
+			// Improper list; print the tail after a dot
+			return ret + " . " + elementToString(l.next) + ")"
+		}
+		if next != EmptyList {
 			ret = ret + " "
 		}
+		l = next
 	}
 	return ret + ")"
 }
 
+// elementToString prints an element of a list.
+// Quoted symbols drop their quote, since the list they're in is already quoted.
+func elementToString(elem Expression) string {
+	if sym, ok := elem.(QuotedSymbol); ok {
+		return string(sym)
+	}
+	return SexpToString(elem)
+}
+
 func (l *SexpPair) IsLiteral() bool {
 	if l == EmptyList {
 		return true
@@ -1,22 +1,28 @@
-package main
-
-import (
-	"fmt"
-)
+package golftalk
 
 type StackFrame struct {
-	Running    Procedure
-	Args       *SexpPair
-	CurrentEnv *Env
-	Step       int
-	StepInput  Expression
+	Call          *SexpPair // the expression which pushed this frame
+	Running       Procedure
+	Args          *SexpPair
+	CurrentEnv    *Env
+	Step          int
+	StepInput     Expression
+	EvaluatedArgs []Expression
+	Wind          *Wind // set while a dynamic-wind's thunk runs above this frame
+
+	let *parsedLet // set by a let form at its first step, so its arguments are only parsed once
+	at  *SexpPair  // the pair of Call whose element was last handed back to be evaluated, if it was one
+
+	// set by a Profiler, to the procedures whose bodies this frame and those below it are in, as of profileEnv
+	profile    *profileNode
+	profileEnv *Env
 }
 
-func (f *StackFrame) Run(stack *Stack, input Expression) (result Expression, nextEnv *Env, err string) {
+func (f *StackFrame) Run(stack *Stack, input Expression) (result Expression, nextEnv *Env, err error) {
 	if f.Step == -1 && f.Running == nil {
 		proc, ok := input.(Procedure)
 		if !ok {
-			return nil, nil, fmt.Sprintf("Function '%s' to execute was not a valid function.", SexpToString(f.StepInput))
+			return nil, nil, errorf(TypeError, "Function '%s' to execute was not a valid function.", SexpToString(f.StepInput))
 		}
 		f.Running = proc
 		f.Step = 0
@@ -25,12 +31,67 @@ func (f *StackFrame) Run(stack *Stack, input Expression) (result Expression, nex
 	return f.Running.Run(f, stack)
 }
 
+// callWith hands the frame over to proc, as though proc had just been called with args, which are already evaluated.
+func (f *StackFrame) callWith(stack *Stack, proc Procedure, args []Expression) (result Expression, nextEnv *Env, err error) {
+	f.Running, f.Step, f.Args, f.EvaluatedArgs = proc, 0, EmptyList, args
+	return proc.Run(f, stack)
+}
+
+// NextArg is used by procedures that take evaluated arguments.
+// Each call collects the result of the previous step into EvaluatedArgs and hands back the next argument expression to evaluate.
+// Once every argument has been evaluated, done is true.
+func (f *StackFrame) NextArg() (next Expression, done bool, err error) {
+	if f.Step > 1 {
+		f.EvaluatedArgs = append(f.EvaluatedArgs, f.StepInput)
+	}
+
+	if f.Args == EmptyList {
+		return nil, true, nil
+	}
+
+	next, f.at = f.Args.val, f.Args
+	var argsOk bool
+	f.Args, argsOk = f.Args.next.(*SexpPair)
+	if !argsOk {
+		return nil, true, errorf(SyntaxError, "Invalid argument list")
+	}
+	return next, false, nil
+}
+
+// Name describes what's running in the frame: whatever it was called as, or else the procedure's own name.
+func (f *StackFrame) Name() string {
+	if f.Call != nil && isIdentifier(f.Call.val) {
+		return string(baseSymbol(f.Call.val))
+	}
+
+	switch proc := f.Running.(type) {
+	case *Proc:
+		if proc.Name != "" {
+			return proc.Name
+		}
+	case *CaseLambda:
+		if proc.Name != "" {
+			return proc.Name
+		}
+	case *GoProc:
+		if proc.Name != "" {
+			return proc.Name
+		}
+	}
+	if f.Running != nil {
+		return f.Running.String()
+	}
+	return "#<procedure>"
+}
+
 type Stack []StackFrame
 
-func (s *Stack) Push(args *SexpPair, env *Env) {
+// Push adds a frame for evaluating a call, whose argument list must be a proper list.
+func (s *Stack) Push(call *SexpPair, env *Env) {
+	args, _ := call.next.(*SexpPair)
 	// Running will be set the next time this stack frame is run, to whatever
 	// is fed to this special step as input (starts at step -1
-	*s = Stack(append(*s, StackFrame{nil, args, env, -1, nil}))
+	*s = Stack(append(*s, StackFrame{Call: call, Args: args, CurrentEnv: env, Step: -1, at: call}))
 }
 
 func (s *Stack) Pop() {
@@ -41,6 +102,35 @@ func (s *Stack) Empty() bool {
 	return len(*s) == 0
 }
 
-func (s *Stack) RunTop(input Expression) (result Expression, nextEnv *Env, err string) {
+// Pos returns where the frame's call was read from, if it was parsed from source.
+func (f *StackFrame) Pos() *SourcePos {
+	if f.Call == nil {
+		return nil
+	}
+	return f.Call.open
+}
+
+// Trace describes the procedures on the stack and where they were called, innermost first.
+func (s Stack) Trace() []TraceEntry {
+	entries := make([]TraceEntry, len(s))
+	for i := range s {
+		entries[len(s)-1-i] = TraceEntry{s[i].Name(), s[i].Pos()}
+	}
+	return entries
+}
+
+func (s *Stack) RunTop(input Expression) (result Expression, nextEnv *Env, err error) {
 	return (&((*s)[len(*s)-1])).Run(s, input)
 }
+
+// Copy returns a snapshot of the stack which can be resumed any number of times.
+// Frames are copied by value, and their evaluated arguments are clipped so that appending to one copy never clobbers another.
+func (s Stack) Copy() Stack {
+	dup := make(Stack, len(s))
+	copy(dup, s)
+	for i := range dup {
+		args := dup[i].EvaluatedArgs
+		dup[i].EvaluatedArgs = args[:len(args):len(args)]
+	}
+	return dup
+}
@@ -0,0 +1,99 @@
+package golftalk
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Traced wraps a procedure so each call to it, and what it returns, is written to the trace port of the interpreter it was traced in.
+// Calls are indented by how deep in the Stack they're made.
+type Traced struct {
+	Proc  Procedure
+	Name  string
+	ports *outputPorts
+}
+
+var _ Procedure = &Traced{}
+
+func (t *Traced) Run(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, err error) {
+	frame.Step++
+
+	// Evaluate the arguments in the caller's Env
+	next, done, err := frame.NextArg()
+	if err != nil {
+		return nil, nil, err
+	}
+	if !done {
+		return next, frame.CurrentEnv, nil
+	}
+
+	depth := len(*stack) - 1
+	var caller *tracedReturn
+	if depth > 0 {
+		caller, _ = (*stack)[depth-1].Running.(*tracedReturn)
+	}
+	if caller != nil {
+		// A tail call from a traced procedure returns to the same place, so it's shown at the same depth
+		depth = caller.Depth
+	}
+	call := toCode(append([]Expression{Symbol(t.Name)}, frame.EvaluatedArgs...)...)
+	fmt.Fprintf(t.ports.Trace.Writer, "%s>%s\n", strings.Repeat(" ", depth), SexpToString(call))
+
+	if caller != nil {
+		// The caller's frame reports what's returned, so ours can go, keeping tail calls in constant space
+		return frame.callWith(stack, t.Proc, frame.EvaluatedArgs)
+	}
+
+	// Our frame stays on the stack until the procedure returns, to report what it returned, and the procedure gets a frame above it
+	args := frame.EvaluatedArgs
+	frame.Running = &tracedReturn{t, depth}
+	*stack = append(*stack, StackFrame{Call: frame.Call, CurrentEnv: frame.CurrentEnv})
+	return (*stack)[len(*stack)-1].callWith(stack, t.Proc, args)
+}
+
+func (t *Traced) GiveName(name string) {
+	t.Proc.GiveName(name)
+}
+
+func (t *Traced) String() string {
+	return t.Proc.String()
+}
+
+func (t *Traced) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return t, env, nil
+}
+
+func (_ *Traced) IsLiteral() bool {
+	return true
+}
+
+// tracedReturn runs in the frame of a call to a traced procedure once the procedure has been called, and reports what it returns.
+type tracedReturn struct {
+	Traced *Traced
+	Depth  int
+}
+
+var _ Procedure = &tracedReturn{}
+
+func (r *tracedReturn) Run(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, err error) {
+	fmt.Fprintf(r.Traced.ports.Trace.Writer, "%s<%s\n", strings.Repeat(" ", r.Depth), SexpToString(frame.StepInput))
+	stack.Pop()
+	return frame.StepInput, frame.CurrentEnv, nil
+}
+
+func (_ *tracedReturn) GiveName(name string) {
+	//dont care
+	return
+}
+
+func (r *tracedReturn) String() string {
+	return r.Traced.String()
+}
+
+func (r *tracedReturn) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return r, env, nil
+}
+
+func (_ *tracedReturn) IsLiteral() bool {
+	return true
+}
@@ -0,0 +1,83 @@
+package golftalk
+
+// Wind holds the thunks of a dynamic-wind whose extent is in effect.
+// Frames are copied when continuations are captured, so the pointer identifies the extent across copies of the stack.
+type Wind struct {
+	Before Procedure
+	After  Procedure
+}
+
+// winds returns the indices of the frames with a dynamic-wind in effect, outermost first.
+func (s Stack) winds() (indices []int) {
+	for i := range s {
+		if s[i].Wind != nil {
+			indices = append(indices, i)
+		}
+	}
+	return
+}
+
+// jump replaces the stack with a copy of target, and then carries on by evaluating next in nextEnv.
+// On the way, the after thunks of the dynamic-winds being left are run, innermost first, and then the before thunks of those being entered, outermost first.
+// Each thunk runs beneath its dynamic-wind, with a rewind frame waiting on it to take the next step.
+func (s *Stack) jump(target Stack, next Expression, nextEnv *Env) (result Expression, resultEnv *Env, err error) {
+	leaving, entering := s.winds(), target.winds()
+	shared := 0
+	for shared < len(leaving) && shared < len(entering) && (*s)[leaving[shared]].Wind == target[entering[shared]].Wind {
+		shared++
+	}
+
+	if shared < len(leaving) {
+		i := leaving[len(leaving)-1]
+		frame := (*s)[i]
+		*s = append((*s)[:i], StackFrame{Running: &rewind{Target: target, Next: next, NextEnv: nextEnv}, CurrentEnv: frame.CurrentEnv})
+		return toCode(frame.Wind.After), frame.CurrentEnv, nil
+	}
+
+	if shared < len(entering) {
+		i := entering[shared]
+		frame := target[i]
+		*s = append(target[:i].Copy(), StackFrame{Running: &rewind{target, i + 1, next, nextEnv}, CurrentEnv: frame.CurrentEnv})
+		return toCode(frame.Wind.Before), frame.CurrentEnv, nil
+	}
+
+	*s = target.Copy()
+	return next, nextEnv, nil
+}
+
+// rewind waits on a before or after thunk run by Stack.jump.
+type rewind struct {
+	Target   Stack
+	Entering int // height of Target to resume at once a before thunk returns, or 0 for an after thunk
+	Next     Expression
+	NextEnv  *Env
+}
+
+var _ Procedure = &rewind{}
+
+func (r *rewind) Run(frame *StackFrame, stack *Stack) (result Expression, nextEnv *Env, err error) {
+	if r.Entering > 0 {
+		// The dynamic-wind is in effect now that its before thunk has returned
+		*stack = r.Target[:r.Entering].Copy()
+	} else {
+		stack.Pop()
+	}
+	return stack.jump(r.Target, r.Next, r.NextEnv)
+}
+
+func (_ *rewind) GiveName(name string) {
+	//dont care
+	return
+}
+
+func (_ *rewind) String() string {
+	return "#<rewind>"
+}
+
+func (r *rewind) Eval(_ *Stack, env *Env) (result Expression, nextEnv *Env, err error) {
+	return r, env, nil
+}
+
+func (_ *rewind) IsLiteral() bool {
+	return true
+}